@@ -0,0 +1,230 @@
+// Package client talks to the gRPC EventStore service of a running node
+// (serve -grpc). Client implements orbitdb.Store, so code written against
+// that interface can use a local or a remote store interchangeably.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/nbd-wtf/go-nostr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	nostrstore "github.com/maoaixiao1314/orbitdb/orbitdb"
+	"github.com/maoaixiao1314/orbitdb/orbitdbpb"
+)
+
+// Options configures a Client.
+type Options struct {
+	// Token is sent with every call as "authorization: Bearer <token>"
+	// metadata; it must match grpc.token of the node.
+	Token string
+	// TLS, when set, makes Dial connect over TLS with this configuration;
+	// otherwise Dial connects without TLS, which only suits a node on the
+	// same host. A node reachable from other hosts requires TLS.
+	TLS *tls.Config
+	// DialOptions are appended to the defaults of Dial.
+	DialOptions []grpc.DialOption
+}
+
+// Client is an orbitdb.Store backed by a remote node.
+type Client struct {
+	conn   *grpc.ClientConn
+	rpc    orbitdbpb.EventStoreClient
+	token  string
+	closed atomic.Bool
+	// done is closed by Close to end the subscriptions
+	done chan struct{}
+}
+
+var _ nostrstore.Store = (*Client)(nil)
+
+// Dial connects to the gRPC service of a node at addr, e.g.
+// "localhost:5090". opts may be nil.
+func Dial(addr string, opts *Options) (*Client, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	creds := insecure.NewCredentials()
+	if opts.TLS != nil {
+		creds = credentials.NewTLS(opts.TLS)
+	}
+	dialOpts := append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, opts.DialOptions...)
+	conn, err := grpc.NewClient(addr, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	c := New(conn, opts)
+	c.conn = conn
+	return c, nil
+}
+
+// New returns a client using an existing connection, e.g. to an in-process
+// server. Close does not close cc. opts may be nil.
+func New(cc grpc.ClientConnInterface, opts *Options) *Client {
+	c := &Client{rpc: orbitdbpb.NewEventStoreClient(cc), done: make(chan struct{})}
+	if opts != nil {
+		c.token = opts.Token
+	}
+	return c
+}
+
+// outgoing adds the token to the metadata of ctx.
+func (c *Client) outgoing(ctx context.Context) context.Context {
+	if c.token == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+c.token)
+}
+
+// SaveEvent stores event on the node. The node checks its ID, signature
+// and relay policies; saving an event already stored succeeds.
+func (c *Client) SaveEvent(ctx context.Context, event *nostr.Event) error {
+	if event == nil {
+		return errors.New("event must not be nil")
+	}
+	if c.closed.Load() {
+		return nostrstore.ErrClosed
+	}
+	_, err := c.rpc.SaveEvent(c.outgoing(ctx), &orbitdbpb.SaveEventRequest{Event: orbitdbpb.EventToProto(event)})
+	return err
+}
+
+// QueryEvents returns the events matching filter, newest first. Errors of
+// the call itself are returned; the channel is closed once every event has
+// been received, when ctx is done or when the stream fails.
+func (c *Client) QueryEvents(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+	stream, err := c.rpc.QueryEvents(c.outgoing(ctx), &orbitdbpb.QueryEventsRequest{Filter: orbitdbpb.FilterToProto(filter)})
+	if err != nil {
+		return nil, err
+	}
+	// The first message tells whether the call was accepted
+	first, err := stream.Recv()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	events := make(chan *nostr.Event)
+	go func() {
+		defer close(events)
+		for resp := first; resp != nil; {
+			select {
+			case events <- orbitdbpb.EventFromProto(resp.GetEvent()):
+			case <-ctx.Done():
+				return
+			}
+			next, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			resp = next
+		}
+	}()
+	return events, nil
+}
+
+// CountEvents returns the number of events matching filter.
+func (c *Client) CountEvents(ctx context.Context, filter nostr.Filter) (int64, error) {
+	resp, err := c.rpc.CountEvents(c.outgoing(ctx), &orbitdbpb.CountEventsRequest{Filter: orbitdbpb.FilterToProto(filter)})
+	if err != nil {
+		return 0, err
+	}
+	return resp.GetCount(), nil
+}
+
+// DeleteEvent removes the event with the ID of event from the node. An
+// event that is not stored fails with orbitdb.ErrNotFound.
+func (c *Client) DeleteEvent(ctx context.Context, event *nostr.Event) error {
+	if event == nil {
+		return errors.New("event must not be nil")
+	}
+	if c.closed.Load() {
+		return nostrstore.ErrClosed
+	}
+	_, err := c.rpc.DeleteEvent(c.outgoing(ctx), &orbitdbpb.DeleteEventRequest{Id: event.ID})
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("%w: %s", nostrstore.ErrNotFound, event.ID)
+	}
+	return err
+}
+
+// Subscribe returns the events written to or replicated into the database
+// of the node after the call that match any of filters; no filter matches
+// every event. It returns once the node has registered the subscription, so
+// events saved after it returns are not missed. The channel is closed when
+// ctx is done, the client is closed or the stream fails, e.g. when the node
+// shuts down or drops a client that reads too slowly.
+func (c *Client) Subscribe(ctx context.Context, filters nostr.Filters) (chan *nostr.Event, error) {
+	if c.closed.Load() {
+		return nil, nostrstore.ErrClosed
+	}
+	req := &orbitdbpb.SubscribeRequest{}
+	for _, filter := range filters {
+		req.Filters = append(req.Filters, orbitdbpb.FilterToProto(filter))
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.rpc.Subscribe(c.outgoing(ctx), req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The node sends the header once the subscription is active; a stream
+	// that ends without one carries the error of the call
+	md, err := stream.Header()
+	if err == nil && md == nil {
+		if _, err = stream.Recv(); errors.Is(err, io.EOF) {
+			err = errors.New("subscription ended before it started")
+		}
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	events := make(chan *nostr.Event)
+	go func() {
+		defer close(events)
+		defer cancel()
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			select {
+			case events <- orbitdbpb.EventFromProto(resp.GetEvent()):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Close ends the subscriptions and closes the connection opened by Dial;
+// saving, deleting and subscribing fail with orbitdb.ErrClosed afterwards.
+func (c *Client) Close() {
+	if c.closed.Swap(true) {
+		return
+	}
+	close(c.done)
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+}
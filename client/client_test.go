@@ -0,0 +1,120 @@
+package client_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/maoaixiao1314/orbitdb/client"
+	nostrstore "github.com/maoaixiao1314/orbitdb/orbitdb"
+	"github.com/maoaixiao1314/orbitdb/orbitdb/storetest"
+)
+
+// serve starts an in-process gRPC service backed by a new MemoryStore and
+// returns a client connected to it.
+func serve(t *testing.T) (*client.Client, error) {
+	lis := bufconn.Listen(1 << 20)
+	service := nostrstore.NewGRPCService(nostrstore.NewMemoryStore(), nil)
+	srv := grpc.NewServer()
+	service.Register(srv)
+	go srv.Serve(lis)
+	t.Cleanup(func() {
+		service.Close()
+		srv.Stop()
+	})
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { conn.Close() })
+	return client.New(conn, nil), nil
+}
+
+func TestClient(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) nostrstore.Store {
+		c, err := serve(t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	})
+}
+
+// selfSigned returns a certificate for 127.0.0.1 and a pool trusting it.
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestDialTLS(t *testing.T) {
+	cert, pool := selfSigned(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := nostrstore.NewGRPCService(nostrstore.NewMemoryStore(), nil)
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}})))
+	service.Register(srv)
+	go srv.Serve(lis)
+	t.Cleanup(func() {
+		service.Close()
+		srv.Stop()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c, err := client.Dial(lis.Addr().String(), &client.Options{TLS: &tls.Config{RootCAs: pool}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.CountEvents(ctx, nostr.Filter{}); err != nil {
+		t.Fatalf("count over TLS: %v", err)
+	}
+
+	plain, err := client.Dial(lis.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if _, err := plain.CountEvents(ctx, nostr.Filter{}); err == nil {
+		t.Error("a plaintext client reached a TLS server")
+	}
+}
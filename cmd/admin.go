@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serveAdmin starts the admin HTTP server of the node on addr, serving the
+// Prometheus metrics on /metrics and the health checks on /healthz and
+// /readyz. It runs from before the node starts until the node is closed, so
+// that probes tell a starting node from a broken one.
+func (n *node) serveAdmin(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(n.registry, promhttp.HandlerOpts{Registry: n.registry}))
+	mux.Handle("/healthz", healthHandler(n.liveness, true))
+	mux.Handle("/readyz", healthHandler(n.readiness, false))
+	n.http = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := n.http.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			n.log.Error("admin HTTP server stopped", "err", err)
+		}
+	}()
+	n.log.Info("serving admin HTTP", "url", fmt.Sprintf("http://%s", ln.Addr()))
+	return nil
+}
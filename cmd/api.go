@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/nbd-wtf/go-nostr"
+
+	nostrstore "github.com/maoaixiao1314/orbitdb/orbitdb"
+)
+
+const (
+	// apiTokenFile holds the generated API token in the data directory.
+	apiTokenFile = "api.token"
+	// apiMaxResults caps the events returned by one query.
+	apiMaxResults = 1000
+	// apiMaxBody caps the size of request bodies.
+	apiMaxBody = 1 << 20
+)
+
+// serveAPI starts the admin API of the node on addr. Every request must
+// carry the token in an Authorization: Bearer header. The API needs a
+// running node, so it is started after start has returned.
+func (n *node) serveAPI(addr string) error {
+	token, err := loadAPIToken(n.log, n.cfg)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/info", n.handleInfo)
+	mux.HandleFunc("GET /api/v1/peers", n.handlePeers)
+	mux.HandleFunc("GET /api/v1/replication", n.handleReplication)
+	mux.HandleFunc("GET /api/v1/events/{id}", n.handleGetEvent)
+	mux.HandleFunc("DELETE /api/v1/events/{id}", n.handleDeleteEvent)
+	mux.HandleFunc("GET /api/v1/events", n.handleQuery)
+	mux.HandleFunc("POST /api/v1/query", n.handleQuery)
+	mux.HandleFunc("POST /api/v1/compact", n.handleCompact)
+	mux.HandleFunc("GET /api/v1/export", n.handleExport)
+	n.api = &http.Server{
+		Handler:           requireToken(token, mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := n.api.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			n.log.Error("admin API server stopped", "err", err)
+		}
+	}()
+	n.log.Info("serving admin API", "url", fmt.Sprintf("http://%s/api/v1/", ln.Addr()))
+	return nil
+}
+
+// loadAPIToken returns api.token, or the token stored in api.token_file,
+// which is created with a random token when it does not exist.
+func loadAPIToken(logger *slog.Logger, cfg *nodeConfig) (string, error) {
+	if cfg.API.Token != "" {
+		return cfg.API.Token, nil
+	}
+	path := cfg.API.TokenFile
+	data, err := os.ReadFile(path)
+	if err == nil {
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", fmt.Errorf("API token file %s is empty", path)
+		}
+		return token, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read API token: %w", err)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate API token: %w", err)
+	}
+	token := hex.EncodeToString(raw)
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to save API token: %w", err)
+	}
+	logger.Info("generated API token", "path", path)
+	return token, nil
+}
+
+// requireToken rejects the requests to h that do not carry token.
+func requireToken(token string, h http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="orbitdb"`)
+			writeAPIError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes v as the JSON body of a response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+}
+
+// writeAPIError writes an {"error": ...} body with the given status.
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// apiManifest is the database manifest in /api/v1/info.
+type apiManifest struct {
+	CID              string `json:"cid"`
+	Name             string `json:"name,omitempty"`
+	Type             string `json:"type,omitempty"`
+	AccessController string `json:"access_controller,omitempty"`
+}
+
+// apiAccessController describes the access controller in /api/v1/info.
+type apiAccessController struct {
+	Type  string   `json:"type"`
+	Write []string `json:"write"`
+	Admin []string `json:"admin,omitempty"`
+}
+
+// apiInfo is the body of /api/v1/info. LogLength counts the log entries
+// loaded in memory, which after starting from an index snapshot are only
+// those newer than the snapshot; Events counts the stored events.
+type apiInfo struct {
+	Address          string              `json:"address"`
+	PeerID           string              `json:"peer_id"`
+	Manifest         apiManifest         `json:"manifest"`
+	AccessController apiAccessController `json:"access_controller"`
+	Events           int64               `json:"events"`
+	LogLength        int                 `json:"log_length"`
+	Heads            []string            `json:"heads"`
+}
+
+func (n *node) handleInfo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	addr := n.db.Address()
+	info := apiInfo{
+		Address:  addr.String(),
+		PeerID:   n.ipfs.Identity.String(),
+		Manifest: apiManifest{CID: addr.GetRoot().String()},
+	}
+	if manifest, err := nostrstore.ReadManifest(ctx, n.ipfs.Blockstore, addr.GetRoot()); err != nil {
+		n.log.Warn("failed to read database manifest", "err", err)
+	} else {
+		info.Manifest.Name = manifest.Name
+		info.Manifest.Type = manifest.Type
+		info.Manifest.AccessController = manifest.AccessController
+	}
+
+	ac := n.db.AccessController()
+	info.AccessController.Type = ac.Type()
+	write, err := ac.GetAuthorizedByRole("write")
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "failed to read writers: %v", err)
+		return
+	}
+	info.AccessController.Write = write
+	if admin, err := ac.GetAuthorizedByRole("admin"); err == nil {
+		info.AccessController.Admin = admin
+	}
+
+	count, err := n.adapter.CountEvents(ctx, nostr.Filter{})
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "failed to count events: %v", err)
+		return
+	}
+	info.Events = count
+
+	status := n.repl.Status()
+	info.LogLength = status.LogLength
+	info.Heads = make([]string, 0, len(status.LocalHeads))
+	for _, head := range status.LocalHeads {
+		info.Heads = append(info.Heads, head.String())
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// apiPeer is a connected peer in /api/v1/peers.
+type apiPeer struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs"`
+}
+
+// apiPeers is the body of /api/v1/peers.
+type apiPeers struct {
+	Connected []apiPeer    `json:"connected"`
+	Peering   []peerStatus `json:"peering"`
+}
+
+func (n *node) handlePeers(w http.ResponseWriter, r *http.Request) {
+	network := n.ipfs.PeerHost.Network()
+	peers := apiPeers{Connected: []apiPeer{}, Peering: []peerStatus{}}
+	for _, id := range network.Peers() {
+		p := apiPeer{ID: id.String(), Addrs: []string{}}
+		for _, conn := range network.ConnsToPeer(id) {
+			p.Addrs = append(p.Addrs, conn.RemoteMultiaddr().String())
+		}
+		peers.Connected = append(peers.Connected, p)
+	}
+	sort.Slice(peers.Connected, func(i, j int) bool { return peers.Connected[i].ID < peers.Connected[j].ID })
+	if n.peering != nil {
+		peers.Peering = n.peering.Statuses()
+	}
+	writeJSON(w, http.StatusOK, peers)
+}
+
+func (n *node) handleReplication(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, n.repl.Status())
+}
+
+func (n *node) handleGetEvent(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	evt, err := n.adapter.GetEvent(r.Context(), id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "failed to read event: %v", err)
+		return
+	}
+	if evt == nil {
+		writeAPIError(w, http.StatusNotFound, "event %s not found", id)
+		return
+	}
+	writeJSON(w, http.StatusOK, evt)
+}
+
+func (n *node) handleDeleteEvent(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := r.PathValue("id")
+	evt, err := n.adapter.GetEvent(ctx, id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "failed to read event: %v", err)
+		return
+	}
+	if evt == nil {
+		writeAPIError(w, http.StatusNotFound, "event %s not found", id)
+		return
+	}
+	if err := n.adapter.DeleteEvent(ctx, evt); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, nostrstore.ErrClosed) {
+			status = http.StatusServiceUnavailable
+		}
+		writeAPIError(w, status, "failed to delete event: %v", err)
+		return
+	}
+	n.log.Info("deleted event through the admin API", "id", id)
+	writeJSON(w, http.StatusOK, map[string]string{"deleted": id})
+}
+
+// apiQueryResult is the body of /api/v1/events and /api/v1/query.
+type apiQueryResult struct {
+	Count  int            `json:"count"`
+	Events []*nostr.Event `json:"events"`
+}
+
+// handleQuery returns the events matching the filters given as filter
+// query parameters (GET) or as a filter or array of filters in the body
+// (POST), newest first.
+func (n *node) handleQuery(w http.ResponseWriter, r *http.Request) {
+	var filters nostr.Filters
+	var err error
+	if r.Method == http.MethodPost {
+		filters, err = readFilterBody(http.MaxBytesReader(w, r.Body, apiMaxBody))
+	} else {
+		filters, err = parseFilterParams(r.URL.Query()["filter"])
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid filter: %v", err)
+		return
+	}
+	if len(filters) == 0 {
+		filters = nostr.Filters{{}}
+	}
+
+	events, err := n.queryEvents(r.Context(), filters)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "query failed: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiQueryResult{Count: len(events), Events: events})
+}
+
+// queryEvents returns the union of the events matching filters, newest
+// first. Each filter returns at most its limit, capped at apiMaxResults.
+func (n *node) queryEvents(ctx context.Context, filters nostr.Filters) ([]*nostr.Event, error) {
+	capped := make(nostr.Filters, len(filters))
+	for i, filter := range filters {
+		if filter.Limit <= 0 || filter.Limit > apiMaxResults {
+			filter.Limit = apiMaxResults
+		}
+		capped[i] = filter
+	}
+	ch, err := n.adapter.QueryFilters(ctx, capped)
+	if err != nil {
+		return nil, err
+	}
+	events := []*nostr.Event{}
+	for evt := range ch {
+		events = append(events, evt)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// readFilterBody decodes a filter or an array of filters.
+func readFilterBody(r io.Reader) (nostr.Filters, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = []byte(strings.TrimSpace(string(data)))
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if data[0] == '[' {
+		var filters nostr.Filters
+		if err := json.Unmarshal(data, &filters); err != nil {
+			return nil, err
+		}
+		return filters, nil
+	}
+	var filter nostr.Filter
+	if err := json.Unmarshal(data, &filter); err != nil {
+		return nil, err
+	}
+	return nostr.Filters{filter}, nil
+}
+
+// parseFilterParams decodes each value as a filter in JSON.
+func parseFilterParams(values []string) (nostr.Filters, error) {
+	filters := make(nostr.Filters, 0, len(values))
+	for _, value := range values {
+		var filter nostr.Filter
+		if err := json.Unmarshal([]byte(value), &filter); err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// apiCompaction is the body of /api/v1/compact.
+type apiCompaction struct {
+	IndexSnapshot string `json:"index_snapshot"`
+	DatastoreGC   bool   `json:"datastore_gc"`
+}
+
+// handleCompact writes a snapshot of the document index, so that the next
+// start replays only newer entries, and collects the garbage of the IPFS
+// datastore when it supports it. Blocks are not garbage collected: the
+// oplog entries are not pinned and would be removed.
+func (n *node) handleCompact(w http.ResponseWriter, r *http.Request) {
+	var result apiCompaction
+	path := n.indexSnapshotPath()
+	if err := n.adapter.SaveIndexSnapshot(path); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "failed to save index snapshot: %v", err)
+		return
+	}
+	result.IndexSnapshot = path
+
+	if gc, ok := n.ipfs.Repo.Datastore().(ds.GCDatastore); ok {
+		if err := gc.CollectGarbage(r.Context()); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "failed to collect datastore garbage: %v", err)
+			return
+		}
+		result.DatastoreGC = true
+	}
+	n.log.Info("compacted through the admin API", "index_snapshot", result.IndexSnapshot, "datastore_gc", result.DatastoreGC)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleExport streams the database as JSONL (format=jsonl, the default),
+// restricted to the filter parameters, or as a CARv1 file (format=car).
+func (n *node) handleExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	name := n.db.Address().GetRoot().String()
+
+	var (
+		count int
+		err   error
+	)
+	switch format := query.Get("format"); format {
+	case "jsonl", "":
+		var filters nostr.Filters
+		filters, err = parseFilterParams(query["filter"])
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid filter: %v", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".jsonl"))
+		count, err = n.adapter.ExportEvents(ctx, w, filters)
+		if err != nil {
+			n.log.Error("export through the admin API failed", "format", "jsonl", "events", count, "err", err)
+			return
+		}
+	case "car":
+		w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".car"))
+		count, err = nostrstore.ExportCAR(ctx, w, n.ipfs.Blockstore, n.db, true)
+		if err != nil {
+			n.log.Error("export through the admin API failed", "format", "car", "blocks", count, "err", err)
+			return
+		}
+	default:
+		writeAPIError(w, http.StatusBadRequest, "unknown format %q (want jsonl or car)", format)
+		return
+	}
+	n.log.Info("exported through the admin API", "format", query.Get("format"), "count", count)
+}
@@ -1,6 +1,7 @@
 package main
 
-// DefaultBootstrapPeers are the public IPFS bootstrap nodes
+// DefaultBootstrapPeers are the public IPFS bootstrap nodes. They are only
+// used outside a private swarm and when swarm.public_bootstrap is enabled.
 var DefaultBootstrapPeers = []string{
 	"/dnsaddr/bootstrap.libp2p.io/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
 	"/dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
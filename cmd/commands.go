@@ -0,0 +1,633 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/nbd-wtf/go-nostr"
+
+	nostrstore "github.com/maoaixiao1314/orbitdb/orbitdb"
+)
+
+// command is a subcommand of the binary.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(cmd *command, args []string) error
+
+	// flags registers the flags specific to the command, if any.
+	flags func(fs *flag.FlagSet)
+}
+
+var initCommand = &command{
+	name:    "init",
+	usage:   "init [flags]",
+	summary: "Create (or open) the database and print its address",
+	run:     runInit,
+}
+
+var serveCommand = &command{
+	name:    "serve",
+	usage:   "serve [flags]",
+	summary: "Run a long-lived node until SIGINT/SIGTERM",
+	run:     runServe,
+}
+
+var putCommand = &command{
+	name:    "put",
+	usage:   "put [flags] [event-json...]",
+	summary: "Store nostr events given as arguments or on stdin",
+	run:     runPut,
+}
+
+var getCommand = &command{
+	name:    "get",
+	usage:   "get [flags] <id>",
+	summary: "Print the event with the given ID",
+	run:     runGet,
+}
+
+var queryCommand = &command{
+	name:    "query",
+	usage:   "query [flags] [filter-json]",
+	summary: "Print the events matching a nostr filter (argument or stdin)",
+	run:     runQuery,
+}
+
+var deleteCommand = &command{
+	name:    "delete",
+	usage:   "delete [flags] <id>",
+	summary: "Delete the event with the given ID",
+	run:     runDelete,
+}
+
+var infoCommand = &command{
+	name:    "info",
+	usage:   "info [flags]",
+	summary: "Print the database address, peers, entry count, heads and replication status",
+	run:     runInfo,
+}
+
+var reconcileCommand = &command{
+	name:    "reconcile",
+	usage:   "reconcile [flags] <peer-multiaddr>",
+	summary: "Compare the event set with a peer, pull the missing events and print the divergence report",
+	run:     runReconcile,
+}
+
+var importCommand = &command{
+	name:    "import",
+	usage:   "import [flags] <relay-url...> | import [flags] -file <events.jsonl|->",
+	summary: "Copy the events of external nostr relays or of a JSONL export into the database",
+	run:     runImport,
+	flags: func(fs *flag.FlagSet) {
+		fs.Var(&filterList{list: &importFlags.filters}, "filter", "Nostr filter JSON selecting the events to import from relays (repeatable, default all events)")
+		fs.IntVar(&importFlags.pageSize, "page-size", nostrstore.DefaultImportPageSize, "Number of events requested per page")
+		fs.StringVar(&importFlags.checkpoint, "checkpoint", "", "Checkpoint file (default <data>/import-checkpoints.json)")
+		fs.StringVar(&importFlags.file, "file", "", "Read newline-delimited event JSON from this file (- for stdin) instead of relays")
+	},
+}
+
+// importFlags holds the flags of the import command.
+var importFlags struct {
+	filters    nostr.Filters
+	pageSize   int
+	checkpoint string
+	file       string
+}
+
+var exportCommand = &command{
+	name:    "export",
+	usage:   "export [flags] [output-file]",
+	summary: "Write the events of the database as newline-delimited JSON to a file or stdout",
+	run:     runExport,
+	flags: func(fs *flag.FlagSet) {
+		fs.Var(&filterList{list: &exportFlags.filters}, "filter", "Nostr filter JSON selecting the events to export (repeatable, default all events)")
+	},
+}
+
+// exportFlags holds the flags of the export command.
+var exportFlags struct {
+	filters nostr.Filters
+}
+
+var exportCARCommand = &command{
+	name:    "export-car",
+	usage:   "export-car [flags] [output-file]",
+	summary: "Write the manifest, access controller and complete log of the database as a CAR file or to stdout",
+	run:     runExportCAR,
+	flags: func(fs *flag.FlagSet) {
+		fs.BoolVar(&exportCARFlags.v1, "v1", false, "Write a CARv1 instead of a CARv2 file (always CARv1 on stdout)")
+	},
+}
+
+// exportCARFlags holds the flags of the export-car command.
+var exportCARFlags struct {
+	v1 bool
+}
+
+var importCARCommand = &command{
+	name:    "import-car",
+	usage:   "import-car [flags] <snapshot.car>",
+	summary: "Import a CAR snapshot into the blockstore and open its database without network access",
+	run:     runImportCAR,
+}
+
+// newFlagSet returns a flag set for cmd with the node flags registered.
+func (cmd *command) newFlagSet(cfg *nodeConfig, configPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s\n\n%s\n\nFlags:\n", cmd.usage, cmd.summary)
+		fs.PrintDefaults()
+	}
+	cfg.registerFlags(fs, configPath)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	return fs
+}
+
+// loadConfig parses args for cmd into the merged node configuration.
+func (cmd *command) loadConfig(args []string) (*nodeConfig, *flag.FlagSet, error) {
+	cfg, fs, err := loadNodeConfig(args, cmd.newFlagSet)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := setupLogging(cfg); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs, nil
+}
+
+// withNode parses args for cmd, starts a node, runs fn and closes the node
+// again. fn receives the positional arguments left after the flags.
+func (cmd *command) withNode(args []string, fn func(ctx context.Context, n *node, args []string) error) error {
+	return cmd.withConfiguredNode(args, nil, fn)
+}
+
+// withConfiguredNode is withNode with a setup function that may adjust the
+// configuration, given the positional arguments, before the node starts.
+func (cmd *command) withConfiguredNode(args []string, setup func(cfg *nodeConfig, args []string) error, fn func(ctx context.Context, n *node, args []string) error) error {
+	cfg, fs, err := cmd.loadConfig(args)
+	if err != nil {
+		return err
+	}
+	if setup != nil {
+		if err := setup(cfg, fs.Args()); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	n, err := startNode(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	spanCtx, span := tracer.Start(ctx, "orbitdb."+cmd.name)
+	runErr := fn(spanCtx, n, fs.Args())
+	endSpan(span, runErr)
+	if err := n.shutdown(cfg.ShutdownTimeout); err != nil {
+		n.log.Error("shutdown incomplete", "err", err)
+	}
+	return runErr
+}
+
+func runInit(cmd *command, args []string) error {
+	return cmd.withNode(args, func(ctx context.Context, n *node, args []string) error {
+		fmt.Println(n.db.Address().String())
+		return nil
+	})
+}
+
+func runServe(cmd *command, args []string) error {
+	cfg, _, err := cmd.loadConfig(args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg.admin = true
+	n, err := startNode(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	// Run until SIGINT/SIGTERM, then tear everything down in order
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigCh
+	n.log.Info("shutting down", "signal", sig, "timeout", cfg.ShutdownTimeout)
+
+	// A second signal aborts the orderly shutdown
+	go func() {
+		sig := <-sigCh
+		n.log.Error("exiting immediately", "signal", sig)
+		os.Exit(1)
+	}()
+
+	if err := n.shutdown(cfg.ShutdownTimeout); err != nil {
+		return fmt.Errorf("shutdown incomplete: %w", err)
+	}
+	n.log.Info("shutdown complete")
+	return nil
+}
+
+func runPut(cmd *command, args []string) error {
+	return cmd.withNode(args, func(ctx context.Context, n *node, args []string) error {
+		var events []*nostr.Event
+		if len(args) > 0 {
+			for _, arg := range args {
+				evt := &nostr.Event{}
+				if err := json.Unmarshal([]byte(arg), evt); err != nil {
+					return fmt.Errorf("invalid event %q: %w", arg, err)
+				}
+				events = append(events, evt)
+			}
+		} else {
+			dec := json.NewDecoder(bufio.NewReader(os.Stdin))
+			for {
+				evt := &nostr.Event{}
+				if err := dec.Decode(evt); err == io.EOF {
+					break
+				} else if err != nil {
+					return fmt.Errorf("invalid event on stdin: %w", err)
+				}
+				events = append(events, evt)
+			}
+		}
+
+		for _, evt := range events {
+			if err := checkEvent(evt); err != nil {
+				n.metrics.Reject(nostrstore.RejectInvalid)
+				return err
+			}
+			if err := n.cfg.Relay.Policies.check(evt); err != nil {
+				n.metrics.Reject(nostrstore.RejectPolicy)
+				return err
+			}
+			if err := n.adapter.SaveEvent(ctx, evt); err != nil {
+				return fmt.Errorf("failed to save event %s: %w", evt.ID, err)
+			}
+			fmt.Println(evt.ID)
+		}
+		return nil
+	})
+}
+
+// checkEvent verifies the ID and signature of evt.
+func checkEvent(evt *nostr.Event) error {
+	if evt.ID != evt.GetID() {
+		return fmt.Errorf("event %s: id does not match its content", evt.ID)
+	}
+	if ok, err := evt.CheckSignature(); err != nil {
+		return fmt.Errorf("event %s: %w", evt.ID, err)
+	} else if !ok {
+		return fmt.Errorf("event %s: invalid signature", evt.ID)
+	}
+	return nil
+}
+
+func runGet(cmd *command, args []string) error {
+	return cmd.withNode(args, func(ctx context.Context, n *node, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s", cmd.usage)
+		}
+
+		events, err := n.adapter.QueryEvents(ctx, nostr.Filter{IDs: []string{args[0]}})
+		if err != nil {
+			return err
+		}
+		found := false
+		for evt := range events {
+			found = true
+			if err := printEvent(evt); err != nil {
+				return err
+			}
+		}
+		if !found {
+			return fmt.Errorf("event not found: %s", args[0])
+		}
+		return nil
+	})
+}
+
+func runQuery(cmd *command, args []string) error {
+	return cmd.withNode(args, func(ctx context.Context, n *node, args []string) error {
+		var raw []byte
+		switch len(args) {
+		case 0:
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("failed to read filter from stdin: %w", err)
+			}
+			raw = data
+		case 1:
+			raw = []byte(args[0])
+		default:
+			return fmt.Errorf("usage: %s", cmd.usage)
+		}
+
+		var filter nostr.Filter
+		if strings.TrimSpace(string(raw)) != "" {
+			if err := json.Unmarshal(raw, &filter); err != nil {
+				return fmt.Errorf("invalid filter: %w", err)
+			}
+		}
+
+		events, err := n.adapter.QueryEvents(ctx, filter)
+		if err != nil {
+			return err
+		}
+		for evt := range events {
+			if err := printEvent(evt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func runDelete(cmd *command, args []string) error {
+	return cmd.withNode(args, func(ctx context.Context, n *node, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s", cmd.usage)
+		}
+		if err := n.adapter.DeleteEvent(ctx, &nostr.Event{ID: args[0]}); err != nil {
+			return fmt.Errorf("failed to delete event %s: %w", args[0], err)
+		}
+		fmt.Println(args[0])
+		return nil
+	})
+}
+
+func runInfo(cmd *command, args []string) error {
+	return cmd.withNode(args, func(ctx context.Context, n *node, args []string) error {
+		count, err := n.adapter.CountEvents(ctx, nostr.Filter{})
+		if err != nil {
+			return fmt.Errorf("failed to count events: %w", err)
+		}
+
+		peers := n.ipfs.PeerHost.Network().Peers()
+		heads := n.db.OpLog().Heads().Slice()
+
+		fmt.Printf("Address:   %s\n", n.db.Address().String())
+		if relay := n.cfg.Relay; relay.Name != "" {
+			fmt.Printf("Relay:     %s\n", relay.Name)
+		}
+		fmt.Printf("Peer ID:   %s\n", n.ipfs.Identity.String())
+		fmt.Printf("Peers:     %d\n", len(peers))
+		for _, p := range peers {
+			fmt.Printf("  %s\n", p.String())
+		}
+		if n.peering != nil {
+			fmt.Printf("Peering:\n")
+			for _, st := range n.peering.Statuses() {
+				fmt.Printf("  %s %s", st.ID, st.State)
+				if st.State == peerBackoff {
+					fmt.Printf(" (attempt %d, retry at %s: %s)", st.Attempts, st.NextRetry.Format(time.RFC3339), st.LastError)
+				}
+				fmt.Println()
+			}
+		}
+		// After starting from an index snapshot only the entries newer than
+		// the snapshot are loaded
+		fmt.Printf("Entries:   %d loaded\n", n.db.OpLog().Len())
+		fmt.Printf("Documents: %d\n", count)
+		fmt.Printf("Heads:     %d\n", len(heads))
+		for _, head := range heads {
+			fmt.Printf("  %s\n", head.GetHash().String())
+		}
+
+		repl := n.repl.Status()
+		fmt.Printf("Synced:    %t\n", repl.Synced)
+		fmt.Printf("Queued:    %d (progress %d/%d, loaded %d)\n", repl.Queued, repl.Progress, repl.Max, repl.Loaded)
+		for _, p := range repl.Peers {
+			fmt.Printf("  %s heads=%d missing=%d last_seen=%s", p.Peer, len(p.Heads), len(p.Missing), p.LastSeen.Format(time.RFC3339))
+			if !p.LastSync.IsZero() {
+				fmt.Printf(" last_sync=%s", p.LastSync.Format(time.RFC3339))
+			}
+			fmt.Println()
+		}
+		return nil
+	})
+}
+
+func runReconcile(cmd *command, args []string) error {
+	return cmd.withNode(args, func(ctx context.Context, n *node, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s", cmd.usage)
+		}
+		info, err := peer.AddrInfoFromString(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid peer %s: %w", args[0], err)
+		}
+
+		dialCtx, cancel := context.WithTimeout(ctx, peerDialTimeout)
+		defer cancel()
+		if err := n.ipfs.PeerHost.Connect(dialCtx, *info); err != nil {
+			return fmt.Errorf("failed to connect to %s: %w", info.ID, err)
+		}
+
+		report, err := n.recon.Reconcile(ctx, info.ID, n.adapter)
+		if err != nil {
+			return fmt.Errorf("reconciliation with %s failed: %w", info.ID, err)
+		}
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	})
+}
+
+func runImport(cmd *command, args []string) error {
+	return cmd.withNode(args, func(ctx context.Context, n *node, args []string) error {
+		if importFlags.file != "" {
+			if len(args) != 0 {
+				return fmt.Errorf("usage: %s", cmd.usage)
+			}
+			return importFile(ctx, n, importFlags.file)
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("usage: %s", cmd.usage)
+		}
+		checkpoint := importFlags.checkpoint
+		if checkpoint == "" {
+			checkpoint = filepath.Join(n.cfg.DataDir, "import-checkpoints.json")
+		}
+
+		stats, err := n.adapter.ImportEvents(ctx, &nostrstore.ImportOptions{
+			Relays:         args,
+			Filters:        importFlags.filters,
+			PageSize:       importFlags.pageSize,
+			CheckpointFile: checkpoint,
+			Accept:         n.cfg.Relay.Policies.check,
+		})
+		for _, st := range stats {
+			fmt.Printf("%s: fetched %d, saved %d, duplicates %d, invalid %d, rejected %d, deleted locally %d\n",
+				st.Relay, st.Fetched, st.Saved, st.Duplicates, st.Invalid, st.Rejected, st.Deleted)
+		}
+		return err
+	})
+}
+
+// importFile imports the JSONL events of path, or of stdin for "-", logging
+// the progress as it goes.
+func importFile(ctx context.Context, n *node, path string) error {
+	in := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	st, err := n.adapter.ImportJSONL(ctx, in, &nostrstore.JSONLImportOptions{
+		Accept: n.cfg.Relay.Policies.check,
+		Progress: func(st nostrstore.ImportStats) {
+			n.log.Info("importing events", "path", path, "read", st.Fetched, "saved", st.Saved)
+		},
+	})
+	fmt.Printf("%s: read %d, saved %d, duplicates %d, invalid %d, rejected %d, deleted locally %d\n",
+		path, st.Fetched, st.Saved, st.Duplicates, st.Invalid, st.Rejected, st.Deleted)
+	return err
+}
+
+func runExport(cmd *command, args []string) error {
+	return cmd.withNode(args, func(ctx context.Context, n *node, args []string) error {
+		out := os.Stdout
+		switch len(args) {
+		case 0:
+		case 1:
+			f, err := os.Create(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", args[0], err)
+			}
+			defer f.Close()
+			out = f
+		default:
+			return fmt.Errorf("usage: %s", cmd.usage)
+		}
+
+		count, err := n.adapter.ExportEvents(ctx, out, exportFlags.filters)
+		if err != nil {
+			return err
+		}
+		if out != os.Stdout {
+			if err := out.Close(); err != nil {
+				return fmt.Errorf("failed to write %s: %w", args[0], err)
+			}
+		}
+		n.log.Info("exported events", "events", count)
+		return nil
+	})
+}
+
+func runExportCAR(cmd *command, args []string) error {
+	return cmd.withNode(args, func(ctx context.Context, n *node, args []string) error {
+		var out *os.File
+		v1 := exportCARFlags.v1
+		switch len(args) {
+		case 0:
+			out, v1 = os.Stdout, true
+		case 1:
+			f, err := os.Create(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", args[0], err)
+			}
+			defer f.Close()
+			out = f
+		default:
+			return fmt.Errorf("usage: %s", cmd.usage)
+		}
+
+		count, err := nostrstore.ExportCAR(ctx, out, n.ipfs.Blockstore, n.db, v1)
+		if err != nil {
+			return err
+		}
+		if out != os.Stdout {
+			if err := out.Close(); err != nil {
+				return fmt.Errorf("failed to write %s: %w", args[0], err)
+			}
+		}
+		n.log.Info("exported CAR snapshot", "blocks", count, "address", n.db.Address().String())
+		return nil
+	})
+}
+
+func runImportCAR(cmd *command, args []string) error {
+	setup := func(cfg *nodeConfig, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s", cmd.usage)
+		}
+		cfg.snapshot = args[0]
+		return nil
+	}
+	return cmd.withConfiguredNode(args, setup, func(ctx context.Context, n *node, args []string) error {
+		// The heads are replicated in the background; the log is complete
+		// once all of them have been joined
+		if err := waitEntries(ctx, n.adapter.HasEntry, n.snapshot.Heads); err != nil {
+			return fmt.Errorf("snapshot not fully loaded: %w", err)
+		}
+		fmt.Printf("%s: %d blocks, %d entries loaded\n", n.db.Address(), n.snapshot.Blocks, n.db.OpLog().Len())
+		return nil
+	})
+}
+
+// filterList is a flag.Value collecting repeated nostr filters given as
+// JSON. Like stringList, the first value given replaces the list.
+type filterList struct {
+	list *nostr.Filters
+	set  bool
+}
+
+func (l *filterList) String() string {
+	if l == nil || l.list == nil || len(*l.list) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(*l.list)
+	return string(data)
+}
+
+func (l *filterList) Set(value string) error {
+	var filter nostr.Filter
+	if err := json.Unmarshal([]byte(value), &filter); err != nil {
+		return fmt.Errorf("invalid filter: %w", err)
+	}
+	if !l.set {
+		*l.list = nil
+		l.set = true
+	}
+	*l.list = append(*l.list, filter)
+	return nil
+}
+
+// printEvent writes evt to stdout as a single line of JSON.
+func printEvent(evt *nostr.Event) error {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return fmt.Errorf("failed to encode event %s: %w", evt.ID, err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
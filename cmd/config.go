@@ -0,0 +1,741 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/nbd-wtf/go-nostr"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is prepended to the upper-cased config key path to form the
+// name of the environment variable overriding it, e.g. ORBITDB_DATA_DIR or
+// ORBITDB_RELAY_POLICIES_MAX_EVENT_SIZE.
+const envPrefix = "ORBITDB_"
+
+// configEnv names the environment variable holding the config file path
+// when -config is not given.
+const configEnv = envPrefix + "CONFIG"
+
+// nodeConfig holds the settings of a node. Values come from, in increasing
+// order of precedence: built-in defaults, the YAML config file, ORBITDB_*
+// environment variables and command line flags.
+type nodeConfig struct {
+	DataDir         string              `yaml:"data_dir"`
+	DBAddress       string              `yaml:"db_address"`
+	ListenAddrs     []string            `yaml:"listen_addrs"`
+	BootstrapPeers  []string            `yaml:"bootstrap_peers"`
+	Peers           []string            `yaml:"peers"`
+	IPFSAPI         string              `yaml:"ipfs_api"`
+	ShutdownTimeout time.Duration       `yaml:"shutdown_timeout"`
+	Log             logConfig           `yaml:"log"`
+	WaitSync        time.Duration       `yaml:"wait_sync"`
+	Swarm           swarmConfig         `yaml:"swarm"`
+	Discovery       discoveryConfig     `yaml:"discovery"`
+	Reconcile       reconcileConfig     `yaml:"reconcile"`
+	Snapshot        snapshotConfig      `yaml:"snapshot"`
+	Admin           adminConfig         `yaml:"admin"`
+	API             apiConfig           `yaml:"api"`
+	GRPC            grpcConfig          `yaml:"grpc"`
+	Health          healthConfig        `yaml:"health"`
+	Metrics         metricsConfig       `yaml:"metrics"`
+	Tracing         tracingConfig       `yaml:"tracing"`
+	Mirror          mirrorConfig        `yaml:"mirror"`
+	AccessControl   accessControlConfig `yaml:"access_control"`
+	Relay           relayConfig         `yaml:"relay"`
+
+	// snapshot is a CAR file imported into the blockstore before the
+	// database is opened. It is set by import-car, not by configuration.
+	snapshot string
+	// admin starts the admin HTTP server on Admin.Listen, the admin API on
+	// API.Listen and the gRPC service on GRPC.Listen. It is set by serve,
+	// so that one-shot commands do not bind the ports.
+	admin bool
+	// logger is built from Log by setupLogging once the configuration is
+	// loaded, and used by every component of the node.
+	logger *slog.Logger
+}
+
+// logConfig controls the log output, which goes to stderr.
+type logConfig struct {
+	// Level is debug, info, warn or error.
+	Level string `yaml:"level"`
+	// Format is text (key=value) or json.
+	Format string `yaml:"format"`
+	// Bridge routes the zap loggers of go-orbit-db, libp2p and IPFS into
+	// the same output. Their own levels still apply (GOLOG_LOG_LEVEL).
+	Bridge bool `yaml:"bridge"`
+}
+
+// swarmConfig selects between the public IPFS swarm and a private one
+// whose members share a swarm key.
+type swarmConfig struct {
+	// Private refuses to start unless the swarm key exists.
+	Private bool `yaml:"private"`
+	// KeyFile defaults to swarm.key in the data directory. The swarm is
+	// private whenever the file exists.
+	KeyFile string `yaml:"key_file"`
+	// PublicBootstrap also bootstraps from the public IPFS nodes; it is
+	// ignored in a private swarm.
+	PublicBootstrap bool `yaml:"public_bootstrap"`
+}
+
+// discoveryConfig controls how the node finds other replicas.
+type discoveryConfig struct {
+	// MDNS enables the mDNS discovery of the IPFS node, which announces it
+	// and connects to peers on the local network.
+	MDNS bool `yaml:"mdns"`
+	// TopicPeers finds the replicas advertising the database on the routing
+	// system and keeps the node connected to them and to the peers
+	// subscribed to the pubsub topic of the database.
+	TopicPeers bool `yaml:"topic_peers"`
+	// Interval between two lookups of the topic peers.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// reconcileConfig controls the periodic comparison of the event sets held
+// by this node and its peers.
+type reconcileConfig struct {
+	// Interval between two reconciliation rounds; 0 disables them.
+	Interval time.Duration `yaml:"interval"`
+	// BucketSize is the created_at range summarized by one bucket. Peers
+	// only compare equal summaries when they use the same size.
+	BucketSize time.Duration `yaml:"bucket_size"`
+}
+
+// snapshotConfig controls the periodic snapshot of the document index that
+// lets a restarting node skip replaying the log it covers.
+type snapshotConfig struct {
+	// Interval between two snapshots; 0 disables snapshots altogether.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// adminConfig controls the admin HTTP server of serve, which exposes the
+// metrics and the health checks.
+type adminConfig struct {
+	// Listen is the address of the HTTP server; empty disables it.
+	Listen string `yaml:"listen"`
+}
+
+// apiConfig controls the admin API of serve, which lets operators inspect
+// and maintain the database over HTTP.
+type apiConfig struct {
+	// Listen is the address of the API; empty disables it. Without a host
+	// it binds to 127.0.0.1 only.
+	Listen string `yaml:"listen"`
+	// Token authenticates the requests. When empty it is read from
+	// TokenFile, which is created with a random token if missing.
+	Token string `yaml:"token"`
+	// TokenFile defaults to api.token in the data directory.
+	TokenFile string `yaml:"token_file"`
+}
+
+// listen returns the address the API binds to, empty when it is disabled.
+func (a *apiConfig) listen() string {
+	return loopbackDefault(a.Listen)
+}
+
+// loopbackDefault binds an address without a host to 127.0.0.1 only.
+func loopbackDefault(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// isLoopback reports whether addr binds to the loopback interface only.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(loopbackDefault(addr))
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// grpcConfig controls the gRPC EventStore service of serve, through which
+// backend services read and write events without running their own node.
+type grpcConfig struct {
+	// Listen is the address of the gRPC server; empty disables it. Without
+	// a host it binds to 127.0.0.1 only.
+	Listen string `yaml:"listen"`
+	// Token, when set, must be sent by every call as the metadata
+	// "authorization: Bearer <token>". It is required, together with TLS,
+	// when Listen is not a loopback address.
+	Token string `yaml:"token"`
+	// CertFile and KeyFile are the PEM certificate and private key the
+	// server uses for TLS; both or neither must be set. Without them the
+	// server is plaintext.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// listen returns the address the gRPC server binds to, empty when it is
+// disabled.
+func (g *grpcConfig) listen() string {
+	return loopbackDefault(g.Listen)
+}
+
+// healthConfig sets the thresholds of /readyz.
+type healthConfig struct {
+	// MaxLag is how long replication may stay behind the peers.
+	MaxLag time.Duration `yaml:"max_lag"`
+	// MinPeers is the number of connected peers required.
+	MinPeers int `yaml:"min_peers"`
+}
+
+// metricsConfig is the former setting of the admin server address.
+type metricsConfig struct {
+	// Listen is used when admin.listen is empty.
+	Listen string `yaml:"listen"`
+}
+
+// adminListen returns the address of the admin HTTP server, empty when it
+// is disabled.
+func (c *nodeConfig) adminListen() string {
+	if c.Admin.Listen != "" {
+		return c.Admin.Listen
+	}
+	return c.Metrics.Listen
+}
+
+// tracingConfig selects where OpenTelemetry spans are exported to.
+type tracingConfig struct {
+	// Exporter is otlp (HTTP), otlp-grpc or stdout; empty disables tracing.
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the URL of the OTLP collector. When empty the exporters
+	// read OTEL_EXPORTER_OTLP_ENDPOINT and fall back to localhost.
+	Endpoint string `yaml:"endpoint"`
+	// SampleRatio is the fraction of traces that are recorded.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// mirrorConfig lists the external relays that the events written to or
+// replicated into the database are published to.
+type mirrorConfig struct {
+	Relays []string `yaml:"relays"`
+	// Filter is a nostr filter in JSON; only matching events are published.
+	Filter      string `yaml:"filter"`
+	QueueSize   int    `yaml:"queue_size"`
+	MaxAttempts int    `yaml:"max_attempts"`
+}
+
+// filters returns the parsed mirror filter, or nil when none is set.
+func (m *mirrorConfig) filters() (nostr.Filters, error) {
+	if strings.TrimSpace(m.Filter) == "" {
+		return nil, nil
+	}
+	var filter nostr.Filter
+	if err := json.Unmarshal([]byte(m.Filter), &filter); err != nil {
+		return nil, err
+	}
+	return nostr.Filters{filter}, nil
+}
+
+// accessControlConfig describes the access controller of the database
+// created when no address is given. Changing it changes the address.
+type accessControlConfig struct {
+	Type  string   `yaml:"type"`
+	Write []string `yaml:"write"`
+}
+
+// relayConfig holds the relay information document and the policies events
+// must satisfy before put, import or the gRPC service writes them. The node
+// itself serves no nostr relay.
+type relayConfig struct {
+	Name        string       `yaml:"name"`
+	Description string       `yaml:"description"`
+	Pubkey      string       `yaml:"pubkey"`
+	Contact     string       `yaml:"contact"`
+	Policies    policyConfig `yaml:"policies"`
+}
+
+// policyConfig restricts which events are accepted. Zero values disable
+// the corresponding check.
+type policyConfig struct {
+	MaxEventSize     int           `yaml:"max_event_size"`
+	MaxContentLength int           `yaml:"max_content_length"`
+	AllowedKinds     []int         `yaml:"allowed_kinds"`
+	AllowedPubkeys   []string      `yaml:"allowed_pubkeys"`
+	BlockedPubkeys   []string      `yaml:"blocked_pubkeys"`
+	MaxFutureSkew    time.Duration `yaml:"max_future_skew"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+// defaultNodeConfig returns the built-in defaults.
+func defaultNodeConfig() *nodeConfig {
+	return &nodeConfig{
+		DataDir:         "~/data",
+		ListenAddrs:     []string{"/ip4/0.0.0.0/tcp/4001"},
+		IPFSAPI:         "localhost:5001",
+		ShutdownTimeout: 30 * time.Second,
+		Log: logConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Swarm: swarmConfig{
+			PublicBootstrap: true,
+		},
+		Discovery: discoveryConfig{
+			TopicPeers: true,
+			Interval:   time.Minute,
+		},
+		Reconcile: reconcileConfig{
+			Interval:   10 * time.Minute,
+			BucketSize: 24 * time.Hour,
+		},
+		Snapshot: snapshotConfig{
+			Interval: 10 * time.Minute,
+		},
+		Health: healthConfig{
+			MaxLag: time.Minute,
+		},
+		Tracing: tracingConfig{
+			SampleRatio: 1,
+		},
+		AccessControl: accessControlConfig{
+			Type:  "ipfs",
+			Write: []string{"*"}, // Allow anyone to write
+		},
+	}
+}
+
+// registerFlags binds the node settings to flags of fs. configPath receives
+// the value of -config.
+func (c *nodeConfig) registerFlags(fs *flag.FlagSet, configPath *string) {
+	fs.StringVar(configPath, "config", os.Getenv(configEnv), "YAML config file (env "+configEnv+")")
+	fs.StringVar(&c.DBAddress, "db", c.DBAddress, "OrbitDB address to connect to")
+	fs.StringVar(&c.DataDir, "data", c.DataDir, "Data directory path")
+	fs.Var(&stringList{list: &c.ListenAddrs}, "listen", "Libp2p listen address (repeatable or comma-separated)")
+	fs.Var(&stringList{list: &c.BootstrapPeers}, "bootstrap", "Bootstrap peer multiaddr with /p2p/ ID (repeatable or comma-separated)")
+	fs.Var(&stringList{list: &c.Peers}, "peer", "Peer multiaddr with /p2p/ ID to stay connected to (repeatable or comma-separated)")
+	fs.StringVar(&c.IPFSAPI, "ipfs", c.IPFSAPI, "IPFS API endpoint")
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "Log level: debug, info, warn or error")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "Log format: text or json")
+	fs.BoolVar(&c.Log.Bridge, "log-bridge", c.Log.Bridge, "Also log through go-orbit-db, libp2p and IPFS loggers")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "Maximum time to wait for an orderly shutdown")
+	fs.DurationVar(&c.WaitSync, "wait-sync", c.WaitSync, "When opening -db, wait up to this long for a peer's heads to be loaded (0 disables)")
+	fs.BoolVar(&c.Swarm.Private, "private", c.Swarm.Private, "Refuse to start without a swarm key")
+	fs.StringVar(&c.Swarm.KeyFile, "swarm-key", c.Swarm.KeyFile, "Swarm key of a private network (default <data>/swarm.key)")
+	fs.BoolVar(&c.Discovery.MDNS, "mdns", c.Discovery.MDNS, "Discover and connect to peers on the local network via mDNS")
+	fs.Var(&stringList{list: &c.Mirror.Relays}, "mirror", "Relay URL to publish new events to (repeatable or comma-separated)")
+	fs.DurationVar(&c.Reconcile.Interval, "reconcile-interval", c.Reconcile.Interval, "Interval between reconciliations of the event set with peers (0 disables)")
+	fs.StringVar(&c.Admin.Listen, "admin", c.Admin.Listen, "Address of the admin HTTP server with /metrics, /healthz and /readyz, e.g. :9100 (empty disables)")
+	fs.StringVar(&c.Metrics.Listen, "metrics", c.Metrics.Listen, "Deprecated alias of -admin")
+	fs.StringVar(&c.API.Listen, "api", c.API.Listen, "Address of the token-protected admin API, e.g. :5080 for 127.0.0.1:5080 (empty disables)")
+	fs.StringVar(&c.GRPC.Listen, "grpc", c.GRPC.Listen, "Address of the gRPC EventStore service, e.g. :5090 for 127.0.0.1:5090 (empty disables)")
+	fs.DurationVar(&c.Health.MaxLag, "ready-max-lag", c.Health.MaxLag, "Longest replication lag at which /readyz still reports ready")
+	fs.IntVar(&c.Health.MinPeers, "ready-min-peers", c.Health.MinPeers, "Connected peers required by /readyz")
+	fs.StringVar(&c.Tracing.Exporter, "tracing", c.Tracing.Exporter, "Export OpenTelemetry traces: otlp, otlp-grpc or stdout (empty disables)")
+	fs.StringVar(&c.Tracing.Endpoint, "tracing-endpoint", c.Tracing.Endpoint, "OTLP collector URL (default from OTEL_EXPORTER_OTLP_ENDPOINT)")
+	fs.DurationVar(&c.Snapshot.Interval, "snapshot-interval", c.Snapshot.Interval, "Interval between snapshots of the document index used for fast startup (0 disables)")
+}
+
+// loadNodeConfig parses args with the flag set built by newFlagSet and
+// returns the merged configuration. The flags are parsed twice: once to
+// find the config file, then again on top of the file and environment so
+// that only explicitly given flags override them.
+func loadNodeConfig(args []string, newFlagSet func(cfg *nodeConfig, configPath *string) *flag.FlagSet) (*nodeConfig, *flag.FlagSet, error) {
+	var configPath string
+	if err := newFlagSet(defaultNodeConfig(), &configPath).Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := defaultNodeConfig()
+	if configPath != "" {
+		if err := cfg.loadFile(expandPath(configPath)); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := cfg.applyEnv(os.Environ()); err != nil {
+		return nil, nil, err
+	}
+
+	fs := newFlagSet(cfg, &configPath)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg.DataDir = expandPath(cfg.DataDir)
+	if cfg.Swarm.KeyFile == "" {
+		cfg.Swarm.KeyFile = filepath.Join(cfg.DataDir, swarmKeyFile)
+	}
+	cfg.Swarm.KeyFile = expandPath(cfg.Swarm.KeyFile)
+	if cfg.API.TokenFile == "" {
+		cfg.API.TokenFile = filepath.Join(cfg.DataDir, apiTokenFile)
+	}
+	cfg.API.TokenFile = expandPath(cfg.API.TokenFile)
+	if cfg.GRPC.CertFile != "" {
+		cfg.GRPC.CertFile = expandPath(cfg.GRPC.CertFile)
+	}
+	if cfg.GRPC.KeyFile != "" {
+		cfg.GRPC.KeyFile = expandPath(cfg.GRPC.KeyFile)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs, nil
+}
+
+// loadFile merges the YAML file at path into c. Unknown keys are rejected.
+func (c *nodeConfig) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides the fields of c from ORBITDB_* variables in environ.
+// Lists are comma-separated.
+func (c *nodeConfig) applyEnv(environ []string) error {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(k, envPrefix) {
+			env[k] = v
+		}
+	}
+	return applyEnvFields(reflect.ValueOf(c).Elem(), nil, env)
+}
+
+func applyEnvFields(v reflect.Value, path []string, env map[string]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		fieldPath := append(append([]string{}, path...), key)
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			if err := applyEnvFields(field, fieldPath, env); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := envPrefix + strings.ToUpper(strings.Join(fieldPath, "_"))
+		raw, ok := env[name]
+		if !ok {
+			continue
+		}
+		if err := setFromString(field, raw); err != nil {
+			return fmt.Errorf("%s (%s): %w", name, strings.Join(fieldPath, "."), err)
+		}
+	}
+	return nil
+}
+
+// setFromString parses raw into v according to its type.
+func setFromString(v reflect.Value, raw string) error {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice:
+		items := splitList(raw)
+		s := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setFromString(s.Index(i), item); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// validate checks the merged configuration. Errors name the offending key.
+func (c *nodeConfig) validate() error {
+	var errs []error
+	fail := func(key, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("config %s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if c.DataDir == "" {
+		fail("data_dir", "must not be empty")
+	}
+	if len(c.ListenAddrs) == 0 {
+		fail("listen_addrs", "at least one address is required")
+	}
+	for i, addr := range c.ListenAddrs {
+		if _, err := ma.NewMultiaddr(addr); err != nil {
+			fail(fmt.Sprintf("listen_addrs[%d]", i), "invalid multiaddr %q: %v", addr, err)
+		}
+	}
+	for i, addr := range c.BootstrapPeers {
+		if _, err := peer.AddrInfoFromString(addr); err != nil {
+			fail(fmt.Sprintf("bootstrap_peers[%d]", i), "invalid peer multiaddr %q: %v", addr, err)
+		}
+	}
+	for i, addr := range c.Peers {
+		if info, err := peer.AddrInfoFromString(addr); err != nil {
+			fail(fmt.Sprintf("peers[%d]", i), "invalid peer multiaddr %q: %v", addr, err)
+		} else if len(info.Addrs) == 0 {
+			fail(fmt.Sprintf("peers[%d]", i), "%q has no address to dial", addr)
+		}
+	}
+	if c.ShutdownTimeout <= 0 {
+		fail("shutdown_timeout", "must be positive")
+	}
+	if c.WaitSync < 0 {
+		fail("wait_sync", "must not be negative")
+	}
+	if c.Discovery.TopicPeers && c.Discovery.Interval <= 0 {
+		fail("discovery.interval", "must be positive")
+	}
+	if c.Reconcile.Interval < 0 {
+		fail("reconcile.interval", "must not be negative")
+	}
+	if c.API.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.API.Listen); err != nil {
+			fail("api.listen", "invalid address %q: %v", c.API.Listen, err)
+		}
+	}
+	if c.GRPC.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.GRPC.Listen); err != nil {
+			fail("grpc.listen", "invalid address %q: %v", c.GRPC.Listen, err)
+		} else if !isLoopback(c.GRPC.Listen) {
+			if c.GRPC.Token == "" {
+				fail("grpc.token", "must be set when grpc.listen %q is reachable from other hosts", c.GRPC.Listen)
+			}
+			if c.GRPC.CertFile == "" {
+				fail("grpc.cert_file", "must be set when grpc.listen %q is reachable from other hosts", c.GRPC.Listen)
+			}
+		}
+	}
+	if (c.GRPC.CertFile == "") != (c.GRPC.KeyFile == "") {
+		fail("grpc.key_file", "grpc.cert_file and grpc.key_file must be set together")
+	}
+	if c.Health.MaxLag < 0 {
+		fail("health.max_lag", "must not be negative")
+	}
+	if c.Health.MinPeers < 0 {
+		fail("health.min_peers", "must not be negative")
+	}
+	if c.Snapshot.Interval < 0 {
+		fail("snapshot.interval", "must not be negative")
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		fail("log.level", "must be debug, info, warn or error")
+	}
+	if !containsString(logFormats, c.Log.Format) {
+		fail("log.format", "must be one of %s", strings.Join(logFormats, ", "))
+	}
+	if c.Tracing.Exporter != "" && !containsString(tracingExporters, c.Tracing.Exporter) {
+		fail("tracing.exporter", "must be one of %s", strings.Join(tracingExporters, ", "))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		fail("tracing.sample_ratio", "must be between 0 and 1")
+	}
+	if c.Reconcile.BucketSize < time.Second {
+		fail("reconcile.bucket_size", "must be at least 1s")
+	}
+	for i, url := range c.Mirror.Relays {
+		if !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://") {
+			fail(fmt.Sprintf("mirror.relays[%d]", i), "%q is not a ws:// or wss:// URL", url)
+		}
+	}
+	if _, err := c.Mirror.filters(); err != nil {
+		fail("mirror.filter", "invalid filter: %v", err)
+	}
+	if c.Mirror.QueueSize < 0 {
+		fail("mirror.queue_size", "must not be negative")
+	}
+	if c.Mirror.MaxAttempts < 0 {
+		fail("mirror.max_attempts", "must not be negative")
+	}
+
+	switch c.AccessControl.Type {
+	case "ipfs", "orbitdb", "simple":
+	default:
+		fail("access_control.type", "unknown access controller %q (want ipfs, orbitdb or simple)", c.AccessControl.Type)
+	}
+	if len(c.AccessControl.Write) == 0 {
+		fail("access_control.write", "at least one writer (or \"*\") is required")
+	}
+
+	if c.Relay.Pubkey != "" && !isHexKey(c.Relay.Pubkey) {
+		fail("relay.pubkey", "must be a 64 character hex public key")
+	}
+
+	p := c.Relay.Policies
+	if p.MaxEventSize < 0 {
+		fail("relay.policies.max_event_size", "must not be negative")
+	}
+	if p.MaxContentLength < 0 {
+		fail("relay.policies.max_content_length", "must not be negative")
+	}
+	if p.MaxFutureSkew < 0 {
+		fail("relay.policies.max_future_skew", "must not be negative")
+	}
+	if p.MaxAge < 0 {
+		fail("relay.policies.max_age", "must not be negative")
+	}
+	for i, pk := range p.AllowedPubkeys {
+		if !isHexKey(pk) {
+			fail(fmt.Sprintf("relay.policies.allowed_pubkeys[%d]", i), "%q is not a 64 character hex public key", pk)
+		}
+	}
+	for i, pk := range p.BlockedPubkeys {
+		if !isHexKey(pk) {
+			fail(fmt.Sprintf("relay.policies.blocked_pubkeys[%d]", i), "%q is not a 64 character hex public key", pk)
+		}
+	}
+	for i, kind := range p.AllowedKinds {
+		if kind < 0 || kind > 65535 {
+			fail(fmt.Sprintf("relay.policies.allowed_kinds[%d]", i), "kind %d out of range", kind)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// check returns an error describing why evt violates the policies, or nil.
+func (p *policyConfig) check(evt *nostr.Event) error {
+	if p.MaxEventSize > 0 && len(evt.Serialize()) > p.MaxEventSize {
+		return fmt.Errorf("event %s exceeds the maximum size of %d bytes", evt.ID, p.MaxEventSize)
+	}
+	if p.MaxContentLength > 0 && len(evt.Content) > p.MaxContentLength {
+		return fmt.Errorf("event %s content exceeds %d bytes", evt.ID, p.MaxContentLength)
+	}
+	if len(p.AllowedKinds) > 0 && !containsInt(p.AllowedKinds, evt.Kind) {
+		return fmt.Errorf("event %s has kind %d, which is not allowed", evt.ID, evt.Kind)
+	}
+	if len(p.AllowedPubkeys) > 0 && !containsString(p.AllowedPubkeys, evt.PubKey) {
+		return fmt.Errorf("event %s author %s is not allowed", evt.ID, evt.PubKey)
+	}
+	if containsString(p.BlockedPubkeys, evt.PubKey) {
+		return fmt.Errorf("event %s author %s is blocked", evt.ID, evt.PubKey)
+	}
+	created := evt.CreatedAt.Time()
+	if p.MaxFutureSkew > 0 && time.Until(created) > p.MaxFutureSkew {
+		return fmt.Errorf("event %s is dated too far in the future", evt.ID)
+	}
+	if p.MaxAge > 0 && time.Since(created) > p.MaxAge {
+		return fmt.Errorf("event %s is older than %s", evt.ID, p.MaxAge)
+	}
+	return nil
+}
+
+// expandPath replaces a leading ~ with the home directory of the user.
+func expandPath(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+func isHexKey(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
+
+func containsString(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(slice []int, item int) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// stringList is a flag.Value collecting repeated or comma-separated values.
+// Values given on the command line replace, rather than extend, the list
+// loaded from the config file or environment.
+type stringList struct {
+	list *[]string
+	set  bool
+}
+
+func (l *stringList) String() string {
+	if l == nil || l.list == nil {
+		return ""
+	}
+	return strings.Join(*l.list, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	if !l.set {
+		*l.list = nil
+		l.set = true
+	}
+	*l.list = append(*l.list, splitList(value)...)
+	return nil
+}
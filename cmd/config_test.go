@@ -0,0 +1,238 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFlagSet registers the node flags on a flag set that returns parse
+// errors instead of exiting.
+func testFlagSet(cfg *nodeConfig, configPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cfg.registerFlags(fs, configPath)
+	return fs
+}
+
+// loadTestConfig writes yamlText, when not empty, to a config file passed
+// with -config, sets env and loads the configuration from args.
+func loadTestConfig(t *testing.T, yamlText string, env map[string]string, args ...string) (*nodeConfig, error) {
+	t.Helper()
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	args = append([]string{"-data", t.TempDir()}, args...)
+	if yamlText != "" {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(yamlText), 0644); err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"-config", path}, args...)
+	}
+	cfg, _, err := loadNodeConfig(args, testFlagSet)
+	return cfg, err
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	cases := []struct {
+		name  string
+		yaml  string
+		env   map[string]string
+		args  []string
+		check func(cfg *nodeConfig) (got, want interface{})
+	}{
+		{
+			name:  "defaults",
+			check: func(cfg *nodeConfig) (interface{}, interface{}) { return cfg.Log.Level, "info" },
+		},
+		{
+			name:  "file over defaults",
+			yaml:  "log:\n  level: debug\n",
+			check: func(cfg *nodeConfig) (interface{}, interface{}) { return cfg.Log.Level, "debug" },
+		},
+		{
+			name:  "env over file",
+			yaml:  "log:\n  level: debug\n",
+			env:   map[string]string{"ORBITDB_LOG_LEVEL": "warn"},
+			check: func(cfg *nodeConfig) (interface{}, interface{}) { return cfg.Log.Level, "warn" },
+		},
+		{
+			name:  "flag over env",
+			yaml:  "log:\n  level: debug\n",
+			env:   map[string]string{"ORBITDB_LOG_LEVEL": "warn"},
+			args:  []string{"-log-level", "error"},
+			check: func(cfg *nodeConfig) (interface{}, interface{}) { return cfg.Log.Level, "error" },
+		},
+		{
+			// the second parse starts from the merged values, so flags
+			// that are not given keep them instead of their defaults
+			name:  "absent flag keeps file",
+			yaml:  "reconcile:\n  interval: 5m\n",
+			args:  []string{"-log-level", "error"},
+			check: func(cfg *nodeConfig) (interface{}, interface{}) { return cfg.Reconcile.Interval, 5 * time.Minute },
+		},
+		{
+			name:  "absent flag keeps env",
+			env:   map[string]string{"ORBITDB_RECONCILE_INTERVAL": "7m"},
+			args:  []string{"-log-level", "error"},
+			check: func(cfg *nodeConfig) (interface{}, interface{}) { return cfg.Reconcile.Interval, 7 * time.Minute },
+		},
+		{
+			name: "env list",
+			env:  map[string]string{"ORBITDB_LISTEN_ADDRS": "/ip4/127.0.0.1/tcp/4101, /ip4/127.0.0.1/tcp/4102"},
+			check: func(cfg *nodeConfig) (interface{}, interface{}) {
+				return strings.Join(cfg.ListenAddrs, " "), "/ip4/127.0.0.1/tcp/4101 /ip4/127.0.0.1/tcp/4102"
+			},
+		},
+		{
+			name: "flag list replaces env list",
+			env:  map[string]string{"ORBITDB_LISTEN_ADDRS": "/ip4/127.0.0.1/tcp/4101"},
+			args: []string{"-listen", "/ip4/127.0.0.1/tcp/4201", "-listen", "/ip4/127.0.0.1/tcp/4202"},
+			check: func(cfg *nodeConfig) (interface{}, interface{}) {
+				return strings.Join(cfg.ListenAddrs, " "), "/ip4/127.0.0.1/tcp/4201 /ip4/127.0.0.1/tcp/4202"
+			},
+		},
+		{
+			name:  "nested env key",
+			env:   map[string]string{"ORBITDB_RELAY_POLICIES_MAX_EVENT_SIZE": "1024"},
+			check: func(cfg *nodeConfig) (interface{}, interface{}) { return cfg.Relay.Policies.MaxEventSize, 1024 },
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, c.yaml, c.env, c.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := c.check(cfg); got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestConfigFileFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("log:\n  format: json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadTestConfig(t, "", map[string]string{configEnv: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Log.Format != "json" {
+		t.Errorf("log.format is %q, want json from the file named by %s", cfg.Log.Format, configEnv)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		yaml string
+		env  map[string]string
+		args []string
+		// want are substrings of the error
+		want []string
+	}{
+		{
+			name: "unknown top-level key",
+			yaml: "listen: /ip4/0.0.0.0/tcp/4001\n",
+			want: []string{"config.yaml", "listen"},
+		},
+		{
+			name: "unknown nested key",
+			yaml: "log:\n  levle: debug\n",
+			want: []string{"config.yaml", "levle"},
+		},
+		{
+			name: "wrong type",
+			yaml: "discovery:\n  mdns: often\n",
+			want: []string{"config.yaml", "often"},
+		},
+		{
+			name: "bad env value",
+			env:  map[string]string{"ORBITDB_DISCOVERY_INTERVAL": "soon"},
+			want: []string{"ORBITDB_DISCOVERY_INTERVAL", "discovery.interval"},
+		},
+		{
+			name: "invalid value from file",
+			yaml: "log:\n  level: loud\n",
+			want: []string{"config log.level"},
+		},
+		{
+			name: "invalid value from flag",
+			args: []string{"-reconcile-interval", "-1m"},
+			want: []string{"config reconcile.interval"},
+		},
+		{
+			name: "every invalid key is reported",
+			yaml: "log:\n  format: xml\nwait_sync: -1s\n",
+			want: []string{"config log.format", "config wait_sync"},
+		},
+		{
+			name: "unknown flag",
+			args: []string{"-levels", "debug"},
+			want: []string{"levels"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, c.yaml, c.env, c.args...)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			for _, want := range c.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigGRPC(t *testing.T) {
+	cases := []struct {
+		name                     string
+		listen, token, cert, key string
+		// wantKey is the key named by the error, empty when valid
+		wantKey string
+	}{
+		{name: "disabled"},
+		{name: "port only", listen: ":5090"},
+		{name: "loopback without token", listen: "127.0.0.1:5090"},
+		{name: "localhost without token", listen: "localhost:5090"},
+		{name: "public without token", listen: "0.0.0.0:5090", cert: "cert.pem", key: "key.pem", wantKey: "grpc.token"},
+		{name: "public without TLS", listen: "0.0.0.0:5090", token: "secret", wantKey: "grpc.cert_file"},
+		{name: "public with token and TLS", listen: "0.0.0.0:5090", token: "secret", cert: "cert.pem", key: "key.pem"},
+		{name: "cert without key", listen: "127.0.0.1:5090", cert: "cert.pem", wantKey: "grpc.key_file"},
+		{name: "key without cert", listen: "127.0.0.1:5090", key: "key.pem", wantKey: "grpc.key_file"},
+		{name: "invalid address", listen: "5090", wantKey: "grpc.listen"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, "", map[string]string{
+				"ORBITDB_GRPC_LISTEN":    c.listen,
+				"ORBITDB_GRPC_TOKEN":     c.token,
+				"ORBITDB_GRPC_CERT_FILE": c.cert,
+				"ORBITDB_GRPC_KEY_FILE":  c.key,
+			})
+			if c.wantKey == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := cfg.GRPC.listen(); c.listen != "" && !strings.Contains(got, ":5090") {
+					t.Errorf("listen() returned %q", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "config "+c.wantKey+":") {
+				t.Errorf("error %v does not name %s", err, c.wantKey)
+			}
+		})
+	}
+}
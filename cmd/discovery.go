@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+
+	core "github.com/ipfs/kubo/core"
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+)
+
+// peerDialTimeout bounds a single dial to a discovered peer.
+const peerDialTimeout = 15 * time.Second
+
+// topicPeerTag protects the connections to the peers subscribed to the
+// database topic from the connection manager.
+const topicPeerTag = "orbitdb-topic-peer"
+
+// topicDiscovery keeps the node connected to the other replicas of one
+// database. At most one dial per peer is in flight, and every dial runs in
+// a goroutine tracked by wg.
+type topicDiscovery struct {
+	host  host.Host
+	log   *slog.Logger
+	topic string
+	wg    *sync.WaitGroup
+
+	// known are the replicas found so far; only the discovery loop uses it.
+	known map[peer.ID]bool
+
+	mu      sync.Mutex
+	dialing map[peer.ID]bool
+}
+
+// discoverTopicPeers keeps the node connected to the other replicas of the
+// database until ctx is done. topic is the OrbitDB address. When the node
+// is online it advertises topic on the routing system of ipfsNode and, every
+// interval, dials the peers advertising it. It also protects the
+// connections to the peers subscribed to topic on the pubsub router and
+// redials the replicas found before whose connection has dropped, which
+// needs no DHT and so also works offline and in private swarms. The
+// advertising and dialing goroutines are tracked by wg.
+func discoverTopicPeers(ctx context.Context, logger *slog.Logger, ipfsNode *core.IpfsNode, topic string, interval time.Duration, wg *sync.WaitGroup) {
+	d := &topicDiscovery{
+		host:    ipfsNode.PeerHost,
+		log:     logger,
+		topic:   topic,
+		wg:      wg,
+		known:   make(map[peer.ID]bool),
+		dialing: make(map[peer.ID]bool),
+	}
+
+	var disc discovery.Discovery
+	if ipfsNode.IsOnline && ipfsNode.Routing != nil {
+		disc = drouting.NewRoutingDiscovery(ipfsNode.Routing)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.advertise(ctx, disc, interval)
+		}()
+	} else {
+		logger.Info("routing discovery disabled: the node is offline")
+	}
+	if disc == nil && ipfsNode.PubSub == nil {
+		logger.Warn("topic peer discovery disabled: neither routing nor pubsub is available")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if disc != nil {
+			d.findPeers(ctx, disc, interval)
+		}
+		if ipfsNode.PubSub != nil {
+			for _, p := range ipfsNode.PubSub.ListPeers(topic) {
+				d.found(p, "pubsub")
+			}
+		}
+		for p := range d.known {
+			d.dial(ctx, d.host.Peerstore().PeerInfo(p))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// advertise announces the topic on disc until ctx is done, renewing the
+// announcement before it expires and retrying every retry after a failure.
+func (d *topicDiscovery) advertise(ctx context.Context, disc discovery.Discovery, retry time.Duration) {
+	for {
+		wait := retry
+		ttl, err := disc.Advertise(ctx, d.topic)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			d.log.Debug("failed to advertise the database topic", "err", err)
+		} else if ttl > 0 {
+			wait = ttl * 7 / 8
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// findPeers dials the peers advertising the topic on disc. The lookup is
+// bounded by timeout so a slow routing system does not hold up the loop.
+func (d *topicDiscovery) findPeers(ctx context.Context, disc discovery.Discovery, timeout time.Duration) {
+	findCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	peers, err := disc.FindPeers(findCtx, d.topic)
+	if err != nil {
+		d.log.Debug("failed to find peers of the database topic", "err", err)
+		return
+	}
+	for info := range peers {
+		if info.ID == d.host.ID() {
+			continue
+		}
+		d.found(info.ID, "routing")
+		d.dial(ctx, info)
+	}
+}
+
+// found records p as a replica and protects its connections.
+func (d *topicDiscovery) found(p peer.ID, source string) {
+	if p == d.host.ID() || d.known[p] {
+		return
+	}
+	d.known[p] = true
+	d.host.ConnManager().Protect(p, topicPeerTag)
+	d.log.Info("found replica of the database", "peer", p, "source", source)
+}
+
+// dial connects to info in a tracked goroutine unless it is already
+// connected or a dial to it is in flight.
+func (d *topicDiscovery) dial(ctx context.Context, info peer.AddrInfo) {
+	if info.ID == d.host.ID() || len(info.Addrs) == 0 || d.host.Network().Connectedness(info.ID) == network.Connected {
+		return
+	}
+	d.mu.Lock()
+	if d.dialing[info.ID] {
+		d.mu.Unlock()
+		return
+	}
+	d.dialing[info.ID] = true
+	d.mu.Unlock()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer func() {
+			d.mu.Lock()
+			delete(d.dialing, info.ID)
+			d.mu.Unlock()
+		}()
+		connectPeer(ctx, d.log, d.host, info, "topic "+d.topic)
+	}()
+}
+
+// connectPeer dials info unless it is h itself or already connected.
+func connectPeer(ctx context.Context, logger *slog.Logger, h host.Host, info peer.AddrInfo, source string) {
+	if info.ID == h.ID() || len(info.Addrs) == 0 || h.Network().Connectedness(info.ID) == network.Connected {
+		return
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, peerDialTimeout)
+	defer cancel()
+	if err := h.Connect(dialCtx, info); err != nil {
+		logger.Debug("failed to connect to discovered peer", "peer", info.ID, "source", source, "err", err)
+		return
+	}
+	logger.Info("connected to discovered peer", "peer", info.ID, "source", source)
+}
+
+var portPattern = regexp.MustCompile(`/(tcp|udp)/[0-9]+`)
+
+// withEphemeralPorts returns addrs with their TCP and UDP ports replaced by
+// 0, so a second host can listen on the same interfaces.
+func withEphemeralPorts(addrs []string) []string {
+	out := make([]string, len(addrs))
+	for i, addr := range addrs {
+		out[i] = portPattern.ReplaceAllString(addr, "/$1/0")
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/nbd-wtf/go-nostr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	nostrstore "github.com/maoaixiao1314/orbitdb/orbitdb"
+)
+
+// serveGRPC starts the gRPC EventStore service of the node on addr, over
+// TLS when grpc.cert_file is set. Saved events must pass the relay
+// policies, as with put.
+func (n *node) serveGRPC(addr string) error {
+	var opts []grpc.ServerOption
+	if n.cfg.GRPC.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(n.cfg.GRPC.CertFile, n.cfg.GRPC.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load the gRPC TLS certificate: %w", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		})))
+	}
+	if token := n.cfg.GRPC.Token; token != "" {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				if err := checkGRPCToken(ctx, token); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := checkGRPCToken(ss.Context(), token); err != nil {
+					return err
+				}
+				return handler(srv, ss)
+			}),
+		)
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	n.grpcService = nostrstore.NewGRPCService(n.adapter, &nostrstore.GRPCOptions{
+		Accept: func(evt *nostr.Event) error {
+			return n.cfg.Relay.Policies.check(evt)
+		},
+	})
+	n.grpc = grpc.NewServer(opts...)
+	n.grpcService.Register(n.grpc)
+	go func() {
+		if err := n.grpc.Serve(ln); err != nil {
+			n.log.Error("gRPC server stopped", "err", err)
+		}
+	}()
+	n.log.Info("serving gRPC", "addr", ln.Addr().String(), "auth", n.cfg.GRPC.Token != "", "tls", n.cfg.GRPC.CertFile != "")
+	return nil
+}
+
+// checkGRPCToken verifies the "authorization: Bearer <token>" metadata of
+// an incoming call.
+func checkGRPCToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	want := []byte("Bearer " + token)
+	for _, got := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(got), want) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid token")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// healthCheck is the outcome of one check of /healthz or /readyz.
+type healthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// healthReport is the JSON body of /healthz and /readyz. Status is ok,
+// starting or failing.
+type healthReport struct {
+	Status string        `json:"status"`
+	Checks []healthCheck `json:"checks"`
+}
+
+// liveness checks that the process can serve requests, the IPFS node is
+// online and the store is open. A node that is still starting is alive.
+func (n *node) liveness() healthReport {
+	if !n.started.Load() {
+		return healthReport{Status: "starting", Checks: []healthCheck{{Name: "process", OK: true}}}
+	}
+
+	checks := []healthCheck{{Name: "process", OK: true}}
+	ipfs := healthCheck{Name: "ipfs", OK: n.ipfs.IsOnline, Detail: n.ipfs.Identity.String()}
+	if !ipfs.OK {
+		ipfs.Detail = "offline"
+	}
+	store := healthCheck{Name: "store", OK: !n.adapter.Closed(), Detail: n.db.Address().String()}
+	if !store.OK {
+		store.Detail = "closed"
+	}
+	return newHealthReport(append(checks, ipfs, store))
+}
+
+// readiness checks the liveness, then that replication is at most
+// health.max_lag behind the peers, that at least health.min_peers peers are
+// connected and that the document index has been built.
+func (n *node) readiness() healthReport {
+	live := n.liveness()
+	if live.Status != "ok" {
+		return live
+	}
+
+	lag := n.repl.Lag()
+	status := n.repl.Status()
+	behind := 0
+	for _, p := range status.Peers {
+		if !p.Synced {
+			behind++
+		}
+	}
+	replication := healthCheck{
+		Name:   "replication",
+		OK:     lag <= n.cfg.Health.MaxLag,
+		Detail: fmt.Sprintf("lag %s, %d queued, %d of %d peers behind", lag.Round(time.Millisecond), status.Queued, behind, len(status.Peers)),
+	}
+
+	connected := len(n.ipfs.PeerHost.Network().Peers())
+	peers := healthCheck{
+		Name:   "peers",
+		OK:     connected >= n.cfg.Health.MinPeers,
+		Detail: fmt.Sprintf("%d connected, %d required", connected, n.cfg.Health.MinPeers),
+	}
+
+	// The index is complete once the adapter has merged the log loaded at
+	// startup, on top of the index snapshot
+	index := healthCheck{Name: "index", OK: n.adapter.IndexReady(), Detail: fmt.Sprintf("%d events indexed", n.adapter.IndexedEvents())}
+	if !index.OK {
+		index.Detail = "waiting for the loaded log to be indexed"
+	}
+
+	return newHealthReport(append(live.Checks, replication, peers, index))
+}
+
+// newHealthReport returns a report with status ok when every check passed.
+func newHealthReport(checks []healthCheck) healthReport {
+	report := healthReport{Status: "ok", Checks: checks}
+	for _, c := range checks {
+		if !c.OK {
+			report.Status = "failing"
+		}
+	}
+	return report
+}
+
+// healthHandler serves the report of check: 200 when its status is ok, or
+// starting and okWhileStarting is set, 503 otherwise.
+func healthHandler(check func() healthReport, okWhileStarting bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := check()
+		code := http.StatusServiceUnavailable
+		if report.Status == "ok" || (report.Status == "starting" && okWhileStarting) {
+			code = http.StatusOK
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(report)
+	})
+}
@@ -3,7 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 
@@ -18,6 +18,7 @@ import (
 	// coreiface "github.com/ipfs/kubo/core/coreiface"
 	"github.com/ipfs/kubo/core/node/libp2p"
 	// "github.com/ipfs/kubo/plugin/loader"
+	"github.com/ipfs/kubo/repo"
 	"github.com/ipfs/kubo/repo/fsrepo"
 
 	// 导入 IPFS 数据存储驱动
@@ -27,8 +28,31 @@ import (
 	_ "github.com/ipfs/go-ds-measure"
 )
 
+// IPFSOptions 每次启动时应用到节点的网络选项。它们只修改交给节点的内存中的配置，
+// 不写入仓库的配置文件，因此不会改变与其他程序共用的仓库
+type IPFSOptions struct {
+	// SwarmKey 不为空时节点只与持有相同 swarm key 的节点组成私有网络
+	SwarmKey []byte
+	// Bootstrap 覆盖仓库配置中的引导节点列表
+	Bootstrap []string
+	// Listen 覆盖仓库配置中的 swarm 监听地址，为空时保持不变
+	Listen []string
+	// MDNS 启用局域网 mDNS 节点发现
+	MDNS bool
+	// Logger 为 nil 时使用 slog.Default()
+	Logger *slog.Logger
+}
+
 // InitIPFS 简单初始化 IPFS 节点（只在已存在仓库基础上，不自动初始化新仓库）
-func InitIPFS(repoPath string) (coreiface.CoreAPI, *core.IpfsNode, error) {
+func InitIPFS(repoPath string, opts *IPFSOptions) (coreiface.CoreAPI, *core.IpfsNode, error) {
+	if opts == nil {
+		opts = &IPFSOptions{}
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
 	// 设置默认仓库路径
 	if repoPath == "" {
 		home, err := os.UserHomeDir()
@@ -47,18 +71,16 @@ func InitIPFS(repoPath string) (coreiface.CoreAPI, *core.IpfsNode, error) {
 		repoPath = filepath.Join(home, repoPath[1:])
 	}
 
-	log.Printf("使用 IPFS 仓库路径: %s", repoPath)
+	logger.Info("using IPFS repository", "path", repoPath)
 	plugins, err := loader.NewPluginLoader(repoPath)
 	if err != nil {
-		panic(fmt.Errorf("error loading plugins: %s", err))
+		return nil, nil, fmt.Errorf("加载 IPFS 插件失败: %w", err)
 	}
-
 	if err := plugins.Initialize(); err != nil {
-		panic(fmt.Errorf("error initializing plugins: %s", err))
+		return nil, nil, fmt.Errorf("初始化 IPFS 插件失败: %w", err)
 	}
-
 	if err := plugins.Inject(); err != nil {
-		panic(fmt.Errorf("error initializing plugins: %s", err))
+		return nil, nil, fmt.Errorf("注入 IPFS 插件失败: %w", err)
 	}
 
 	// 检查仓库是否已初始化
@@ -66,29 +88,59 @@ func InitIPFS(repoPath string) (coreiface.CoreAPI, *core.IpfsNode, error) {
 
 	// 如果仓库不存在，初始化它
 	if !exists {
-		log.Printf("初始化 IPFS 仓库: %s", repoPath)
+		logger.Info("initializing IPFS repository", "path", repoPath)
 		if err := initRepo(repoPath); err != nil {
 			return nil, nil, fmt.Errorf("初始化 IPFS 仓库失败: %w", err)
 		}
 	}
 
 	// 打开仓库
-	repo, err := fsrepo.Open(repoPath)
+	fsRepo, err := fsrepo.Open(repoPath)
 	if err != nil {
 		return nil, nil, fmt.Errorf("打开 IPFS 仓库失败: %w", err)
 	}
 
+	cfg, err := fsRepo.Config()
+	if err != nil {
+		fsRepo.Close()
+		return nil, nil, fmt.Errorf("读取 IPFS 配置失败: %w", err)
+	}
+	cfg, err = cfg.Clone()
+	if err != nil {
+		fsRepo.Close()
+		return nil, nil, fmt.Errorf("复制 IPFS 配置失败: %w", err)
+	}
+
+	// 使用配置的引导节点替换默认的公共节点
+	cfg.Bootstrap = opts.Bootstrap
+	if cfg.Bootstrap == nil {
+		cfg.Bootstrap = []string{}
+	}
+	cfg.Discovery.MDNS.Enabled = opts.MDNS
+	if len(opts.Listen) > 0 {
+		cfg.Addresses.Swarm = opts.Listen
+	}
+	if opts.SwarmKey != nil {
+		// 私有网络不能使用公共的 AutoTLS 服务，否则会暴露节点身份
+		cfg.AutoTLS.Enabled = config.False
+	}
+	nodeRepo := &optionsRepo{Repo: fsRepo, cfg: cfg, swarmKey: opts.SwarmKey}
+
 	// 创建节点
 	ctx := context.Background()
 	nodeOptions := &core.BuildCfg{
 		Online:  true,
 		Routing: libp2p.DHTOption,
-		Repo:    repo,
+		Repo:    nodeRepo,
+		ExtraOpts: map[string]bool{
+			"pubsub": true, // OrbitDB 依赖 PubSub
+			"mplex":  true, // 多路复用支持
+		},
 	}
 
 	node, err := core.NewNode(ctx, nodeOptions)
 	if err != nil {
-		repo.Close()
+		nodeRepo.Close()
 		return nil, nil, fmt.Errorf("创建 IPFS 节点失败: %w", err)
 	}
 
@@ -99,10 +151,31 @@ func InitIPFS(repoPath string) (coreiface.CoreAPI, *core.IpfsNode, error) {
 		return nil, nil, fmt.Errorf("创建 IPFS API 失败: %w", err)
 	}
 
-	log.Println("IPFS 节点初始化成功")
+	logger.Info("IPFS node started", "peer", node.Identity)
 	return api, node, nil
 }
 
+// optionsRepo 向节点提供应用了 IPFSOptions 的配置，仓库中的配置文件保持不变。
+// swarmKey 不为 nil 时代替仓库目录中的 swarm.key
+type optionsRepo struct {
+	repo.Repo
+	cfg      *config.Config
+	swarmKey []byte
+}
+
+// Config 返回应用了选项的配置
+func (r *optionsRepo) Config() (*config.Config, error) {
+	return r.cfg, nil
+}
+
+// SwarmKey 返回配置的 swarm key，没有配置时读取仓库中的
+func (r *optionsRepo) SwarmKey() ([]byte, error) {
+	if r.swarmKey != nil {
+		return r.swarmKey, nil
+	}
+	return r.Repo.SwarmKey()
+}
+
 // initRepo 初始化 IPFS 仓库
 func initRepo(repoPath string) error {
 	// 创建目录
@@ -111,7 +184,7 @@ func initRepo(repoPath string) error {
 	}
 
 	// 创建默认配置
-	cfg, err := config.Init(os.Stdout, 2048)
+	cfg, err := config.Init(os.Stderr, 2048)
 	if err != nil {
 		return err
 	}
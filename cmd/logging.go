@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+
+	golog "github.com/ipfs/go-log/v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	nostrstore "github.com/maoaixiao1314/orbitdb/orbitdb"
+)
+
+// logFormats lists the accepted values of log.format.
+var logFormats = []string{"text", "json"}
+
+// newLogger returns the logger described by cfg, writing to w.
+func newLogger(cfg logConfig, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", cfg.Level)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch cfg.Format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text", "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+}
+
+// setupLogging creates the logger of cfg and installs it as the default
+// logger, which the standard log package then writes through, and as the
+// logger of the orbitdb package. With cfg.Log.Bridge the zap loggers of
+// libp2p and IPFS are routed through it as well.
+func setupLogging(cfg *nodeConfig) error {
+	logger, err := newLogger(cfg.Log, os.Stderr)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	nostrstore.SetLogger(logger)
+	if cfg.Log.Bridge {
+		golog.SetPrimaryCore(newSlogCore(logger.Handler()))
+	}
+	cfg.logger = logger
+	return nil
+}
+
+// zapLogger returns the logger handed to go-orbit-db: the node logger when
+// bridging, otherwise one that discards everything as before.
+func zapLogger(cfg *nodeConfig) *zap.Logger {
+	if !cfg.Log.Bridge || cfg.logger == nil {
+		return zap.NewNop()
+	}
+	return zap.New(newSlogCore(cfg.logger.Handler())).Named("orbitdb")
+}
+
+// slogCore is a zapcore.Core writing to a slog.Handler, so that libraries
+// logging through zap end up in the same output as the node.
+type slogCore struct {
+	handler slog.Handler
+}
+
+func newSlogCore(h slog.Handler) zapcore.Core {
+	return &slogCore{handler: h}
+}
+
+func (c *slogCore) Enabled(level zapcore.Level) bool {
+	return c.handler.Enabled(context.Background(), slogLevel(level))
+}
+
+func (c *slogCore) With(fields []zapcore.Field) zapcore.Core {
+	return &slogCore{handler: c.handler.WithAttrs(zapAttrs(fields))}
+}
+
+func (c *slogCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *slogCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	r := slog.NewRecord(ent.Time, slogLevel(ent.Level), ent.Message, 0)
+	if ent.LoggerName != "" {
+		r.AddAttrs(slog.String("component", ent.LoggerName))
+	}
+	r.AddAttrs(zapAttrs(fields)...)
+	return c.handler.Handle(context.Background(), r)
+}
+
+func (c *slogCore) Sync() error {
+	return nil
+}
+
+// slogLevel maps a zap level to the closest slog level.
+func slogLevel(level zapcore.Level) slog.Level {
+	switch {
+	case level < zapcore.InfoLevel:
+		return slog.LevelDebug
+	case level == zapcore.InfoLevel:
+		return slog.LevelInfo
+	case level == zapcore.WarnLevel:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
+
+// zapAttrs converts zap fields to slog attributes.
+func zapAttrs(fields []zapcore.Field) []slog.Attr {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	keys := make([]string, 0, len(enc.Fields))
+	for key := range enc.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, slog.Any(key, enc.Fields[key]))
+	}
+	return attrs
+}
@@ -3,26 +3,19 @@ package main
 import (
 	"context"
 	// "encoding/json"
-	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 
-	orbitdb "berty.tech/go-orbit-db"
-	"berty.tech/go-orbit-db/accesscontroller"
-	"berty.tech/go-orbit-db/iface"
-	shell "github.com/ipfs/go-ipfs-api"
-	core "github.com/ipfs/kubo/core"
-	"github.com/ipfs/kubo/core/coreapi"
-
 	// coreapi "github.com/ipfs/kubo/client/rpc"
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
 	ma "github.com/multiformats/go-multiaddr"
 
 	// Import IPFS data storage drivers
@@ -33,139 +26,82 @@ import (
 	// "github.com/ipfs/kubo/core/node/libp2p"
 )
 
-var (
-	dbAddress  = flag.String("db", "", "OrbitDB address to connect to")
-	dataDir    = flag.String("data", "~/data", "Data directory path")
-	listenAddr = flag.String("listen", "/ip4/0.0.0.0/tcp/4001", "Libp2p listen address")
-	ipfssAPI   = flag.String("ipfs", "localhost:5001", "IPFS API endpoint")
-	Create     = true
-)
+// commands lists the available subcommands in the order shown by usage.
+var commands = []*command{
+	initCommand,
+	serveCommand,
+	putCommand,
+	getCommand,
+	queryCommand,
+	deleteCommand,
+	infoCommand,
+	reconcileCommand,
+	importCommand,
+	exportCommand,
+	exportCARCommand,
+	importCARCommand,
+}
 
 func main() {
-	flag.Parse()
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Setup data directories
-	ipfsDir := filepath.Join(*dataDir, "ipfs")
-	orbitDBDir := filepath.Join(*dataDir, "orbitdb")
-	settingsDir := filepath.Join(*dataDir, "settings")
-
-	// Ensure directories exist
-	for _, dir := range []string{ipfsDir, orbitDBDir, settingsDir} {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			log.Fatalf("Failed to create directory %s: %v", dir, err)
+	// Without a subcommand the binary keeps its historical behaviour of
+	// running a long-lived node, so "orbitdb -data ..." still works.
+	args := os.Args[1:]
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && isHelpFlag(args[0]) {
+			usage()
+			return
 		}
+		args = append([]string{"serve"}, args...)
 	}
-
-	// Get or generate peer identity
-	privKey, peerID, err := getOrCreatePeerID(settingsDir)
-	if err != nil {
-		log.Fatalf("Failed to get peer ID: %v", err)
-	}
-	log.Printf("Using Peer ID: %s", peerID.String())
-
-	// Connect to local IPFS node
-	// ipfsAPI, ipfsNode, err := InitIPFS(ipfsDir)
-	// if err != nil {
-	// 	log.Fatalf("Failed to initialize IPFS: %v", err)
-	// }
-	// defer ipfsNode.Close()
-	node, _ := core.NewNode(ctx, &core.BuildCfg{
-		Online: true, // 必须为 true，OrbitDB 需要网络功能
-		// NilRepo: false, // 需要持久化存储
-		ExtraOpts: map[string]bool{
-			"pubsub": true, // OrbitDB 依赖 PubSub
-			"mplex":  true, // 多路复用支持
-		},
-	})
-	api, _ := coreapi.NewCoreAPI(node)
-	// Initialize IPFS HTTP client
-	sh := shell.NewShell(*ipfssAPI)
-	if sh == nil {
-		log.Fatalf("Failed to initialize IPFS HTTP client")
-	}
-	// 2. 转换为 coreapi 接口
-	// api, err := coreapi.NewClient(sh)
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-	// Setup libp2p host
-	host, err := setupLibp2p(ctx, privKey, *listenAddr)
-	if err != nil {
-		log.Fatalf("Failed to create libp2p host: %v", err)
+	name, args := args[0], args[1:]
+	if name == "help" {
+		usage()
+		return
 	}
 
-	// Print peer addresses
-	addrs := host.Addrs()
-	var addrStrings []string
-	for _, addr := range addrs {
-		addrStrings = append(addrStrings, fmt.Sprintf("%s/p2p/%s", addr.String(), host.ID().String()))
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
 	}
-	log.Printf("Peer addresses: %s", strings.Join(addrStrings, ", "))
-
-	// Create OrbitDB instance
-	// orbit, err := orbitdb.NewOrbitDB(ctx, ipfsAPI, &orbitdb.NewOrbitDBOptions{
-	// 	Directory: &orbitDBDir,
-	// })
-	// Explicitly try to enable pubsub
-	// Create OrbitDB instance with explicit pubsub options
-	orbit, err := orbitdb.NewOrbitDB(ctx, api, &orbitdb.NewOrbitDBOptions{
-		Directory: &orbitDBDir,
-	})
-	if err != nil {
-		log.Fatalf("Failed to create OrbitDB instance: %v", err)
+
+	if err := cmd.run(cmd, args); err != nil {
+		slog.Error("command failed", "command", cmd.name, "err", err)
+		os.Exit(1)
 	}
-	// Open or create database
-	var db iface.DocumentStore
-	if *dbAddress != "" {
-		// Connect to existing database
-		log.Printf("Connecting to database: %s", *dbAddress)
-		dbInstance, err := orbit.Open(ctx, *dbAddress, &orbitdb.CreateDBOptions{
-			Directory: &orbitDBDir,
-			Create:    &Create,
-		})
-		if err != nil {
-			log.Fatalf("Failed to open database: %v", err)
-		}
-		db = dbInstance.(iface.DocumentStore)
-	} else {
-		// Create new database with open write access
-		log.Printf("Creating new database")
-		dbName := "nostr-events"
-
-		// Create database options, including index settings
-		// storeOptions := map[string]interface{}{
-		// 	"indexBy": "_id", // Use _id as the primary index
-		// }
-
-		// indexBy := "_id"
-		dbOptions := &orbitdb.CreateDBOptions{
-			AccessController: &accesscontroller.CreateAccessControllerOptions{
-				Type: "ipfs",
-				Access: map[string][]string{
-					"write": {"*"}, // Allow anyone to write
-				},
-			},
-			Directory: &orbitDBDir,
-			Create:    &Create,
-			// StoreSpecificOpts: storeOptions,
-		}
+}
 
-		dbInstance, err := orbit.Docs(ctx, dbName, dbOptions)
-		if err != nil {
-			log.Fatalf("Failed to create database: %v", err)
+// findCommand returns the subcommand with the given name, or nil.
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
 		}
-		db = dbInstance
-		log.Printf("Database created with address: %s", db.Address().String())
 	}
-	defer db.Close()
+	return nil
+}
+
+// isHelpFlag reports whether arg asks for the top-level usage.
+func isHelpFlag(arg string) bool {
+	switch arg {
+	case "-h", "-help", "--help":
+		return true
+	}
+	return false
+}
 
+// usage prints the list of subcommands.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags] [args]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", filepath.Base(os.Args[0]))
 }
 
 // getOrCreatePeerID loads or creates a peer ID
-func getOrCreatePeerID(settingsDir string) (crypto.PrivKey, peer.ID, error) {
+func getOrCreatePeerID(logger *slog.Logger, settingsDir string) (crypto.PrivKey, peer.ID, error) {
 	keyFile := filepath.Join(settingsDir, "peer.key")
 
 	// Check if key file exists
@@ -193,7 +129,7 @@ func getOrCreatePeerID(settingsDir string) (crypto.PrivKey, peer.ID, error) {
 			return nil, "", fmt.Errorf("failed to save key: %w", err)
 		}
 
-		log.Printf("Generated new peer ID: %s", pid.String())
+		logger.Info("generated new peer ID", "peer", pid)
 		return priv, pid, nil
 	}
 
@@ -216,23 +152,32 @@ func getOrCreatePeerID(settingsDir string) (crypto.PrivKey, peer.ID, error) {
 		return nil, "", fmt.Errorf("failed to get peer ID: %w", err)
 	}
 
-	log.Printf("Loaded existing peer ID: %s", pid.String())
+	logger.Debug("loaded existing peer ID", "peer", pid)
 	return priv, pid, nil
 }
 
-// setupLibp2p creates a libp2p host
-func setupLibp2p(ctx context.Context, privKey crypto.PrivKey, listenAddr string) (host.Host, error) {
-	addr, err := ma.NewMultiaddr(listenAddr)
-	if err != nil {
-		return nil, fmt.Errorf("invalid listen address: %w", err)
+// setupLibp2p creates a libp2p host. A non-nil psk restricts it to the
+// private network sharing that key.
+func setupLibp2p(ctx context.Context, privKey crypto.PrivKey, listenAddrs []string, psk pnet.PSK) (host.Host, error) {
+	addrs := make([]ma.Multiaddr, 0, len(listenAddrs))
+	for _, listenAddr := range listenAddrs {
+		addr, err := ma.NewMultiaddr(listenAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address: %w", err)
+		}
+		addrs = append(addrs, addr)
 	}
 
 	// Create libp2p host with only necessary options
-	host, err := libp2p.New(
-		libp2p.ListenAddrs(addr),
+	opts := []libp2p.Option{
+		libp2p.ListenAddrs(addrs...),
 		libp2p.Identity(privKey),
 		libp2p.EnableRelay(),
-	)
+	}
+	if psk != nil {
+		opts = append(opts, libp2p.PrivateNetwork(psk))
+	}
+	host, err := libp2p.New(opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create libp2p host: %w", err)
 	}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+
+	nostrstore "github.com/maoaixiao1314/orbitdb/orbitdb"
+)
+
+// registerMetrics registers the Go runtime and process collectors, the
+// number of connected peers and the metrics of the store, adapter and
+// replication on the registry of the node. It must run before the adapter
+// is used.
+func (n *node) registerMetrics() error {
+	reg := n.registry
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "orbitdb_connected_peers",
+			Help: "Peers connected to the IPFS node.",
+		}, func() float64 { return float64(len(n.ipfs.PeerHost.Network().Peers())) }),
+	)
+
+	metrics, err := nostrstore.NewMetrics(reg, n.adapter, n.repl)
+	if err != nil {
+		return err
+	}
+	n.metrics = metrics
+	return nil
+}
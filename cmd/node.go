@@ -0,0 +1,692 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	orbitdb "berty.tech/go-orbit-db"
+	"berty.tech/go-orbit-db/accesscontroller"
+	"berty.tech/go-orbit-db/iface"
+	cid "github.com/ipfs/go-cid"
+	shell "github.com/ipfs/go-ipfs-api"
+	core "github.com/ipfs/kubo/core"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+
+	nostrstore "github.com/maoaixiao1314/orbitdb/orbitdb"
+)
+
+// defaultDBName is the name of the document store created when no
+// database address is given.
+const defaultDBName = "nostr-events"
+
+// replicationPollInterval is how often the replication queue is checked
+// while draining it during shutdown.
+const replicationPollInterval = 200 * time.Millisecond
+
+// bootstrapDialTimeout bounds the dial to a single bootstrap peer.
+const bootstrapDialTimeout = 30 * time.Second
+
+// node holds every long-lived component started by the binary so they can
+// be torn down in the reverse order of their creation.
+type node struct {
+	cfg     *nodeConfig
+	log     *slog.Logger
+	ipfs    *core.IpfsNode
+	host    host.Host
+	peering *peeringService
+	orbit   iface.OrbitDB
+	db      iface.DocumentStore
+	adapter *nostrstore.OrbitDBAdapter
+	repl    *nostrstore.ReplicationTracker
+	heads   *nostrstore.HeadsExchange
+	recon   *nostrstore.Reconciler
+	mirror  *nostrstore.Mirror
+
+	// snapshot is the CAR snapshot imported at startup, if any.
+	snapshot *nostrstore.CARSnapshot
+
+	registry *prometheus.Registry
+	metrics  *nostrstore.Metrics
+	// http is the admin HTTP server, when started by serve.
+	http *http.Server
+	// api is the admin API server, when started by serve.
+	api *http.Server
+	// grpc serves grpcService, when started by serve.
+	grpc        *grpc.Server
+	grpcService *nostrstore.GRPCService
+	// started is set once start has returned successfully; until then the
+	// components are being created and must not be used by the handlers.
+	started atomic.Bool
+	// stopTracing flushes the spans still buffered for the exporter.
+	stopTracing func(context.Context) error
+
+	// ctx is cancelled on Close to stop the background tasks tracked by wg.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// startNode starts the IPFS node, the libp2p host and OrbitDB, then opens
+// cfg.DBAddress, or creates the default document store when it is empty.
+// On failure everything already started is closed again.
+func startNode(ctx context.Context, cfg *nodeConfig) (*node, error) {
+	stopTracing, err := setupTracing(ctx, cfg.Tracing)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.logger == nil {
+		cfg.logger = slog.Default()
+	}
+	n := &node{
+		cfg:         cfg,
+		log:         cfg.logger.With("component", "node"),
+		registry:    prometheus.NewRegistry(),
+		stopTracing: stopTracing,
+	}
+	n.ctx, n.cancel = context.WithCancel(ctx)
+
+	if addr := cfg.adminListen(); cfg.admin && addr != "" {
+		if err := n.serveAdmin(addr); err != nil {
+			if closeErr := n.shutdown(cfg.ShutdownTimeout); closeErr != nil {
+				n.log.Error("shutdown incomplete", "err", closeErr)
+			}
+			return nil, err
+		}
+	}
+
+	ctx, span := tracer.Start(ctx, "orbitdb.node.Start")
+	err = n.start(ctx, cfg)
+	endSpan(span, err)
+	if err != nil {
+		if closeErr := n.shutdown(cfg.ShutdownTimeout); closeErr != nil {
+			n.log.Error("shutdown incomplete", "err", closeErr)
+		}
+		return nil, err
+	}
+	n.started.Store(true)
+
+	if addr := cfg.API.listen(); cfg.admin && addr != "" {
+		if err := n.serveAPI(addr); err != nil {
+			if closeErr := n.shutdown(cfg.ShutdownTimeout); closeErr != nil {
+				n.log.Error("shutdown incomplete", "err", closeErr)
+			}
+			return nil, err
+		}
+	}
+	if addr := cfg.GRPC.listen(); cfg.admin && addr != "" {
+		if err := n.serveGRPC(addr); err != nil {
+			if closeErr := n.shutdown(cfg.ShutdownTimeout); closeErr != nil {
+				n.log.Error("shutdown incomplete", "err", closeErr)
+			}
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func (n *node) start(ctx context.Context, cfg *nodeConfig) error {
+	// Setup data directories
+	ipfsDir := filepath.Join(cfg.DataDir, "ipfs")
+	orbitDBDir := filepath.Join(cfg.DataDir, "orbitdb")
+	settingsDir := filepath.Join(cfg.DataDir, "settings")
+
+	// Ensure directories exist
+	for _, dir := range []string{ipfsDir, orbitDBDir, settingsDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+	}
+
+	// Get or generate peer identity
+	privKey, peerID, err := getOrCreatePeerID(n.log, settingsDir)
+	if err != nil {
+		return fmt.Errorf("failed to get peer ID: %w", err)
+	}
+	n.log.Info("using peer ID", "peer", peerID)
+
+	// Load the swarm key before anything listens on the network
+	key, err := loadSwarmKey(&cfg.Swarm)
+	if err != nil {
+		return err
+	}
+	var rawKey []byte
+	var psk pnet.PSK
+	if key != nil {
+		rawKey, psk = key.raw, key.psk
+		n.log.Info("private swarm enabled", "key_file", cfg.Swarm.KeyFile)
+	}
+	bootstrap := bootstrapPeers(n.log, cfg, key)
+
+	// Open the IPFS repository in the data directory so that entries written
+	// by one invocation are still there for the next one.
+	api, ipfsNode, err := InitIPFS(ipfsDir, &IPFSOptions{
+		SwarmKey:  rawKey,
+		Bootstrap: bootstrap,
+		Listen:    cfg.ListenAddrs,
+		MDNS:      cfg.Discovery.MDNS,
+		Logger:    cfg.logger.With("component", "ipfs"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize IPFS: %w", err)
+	}
+	n.ipfs = ipfsNode
+
+	// Initialize IPFS HTTP client
+	sh := shell.NewShell(cfg.IPFSAPI)
+	if sh == nil {
+		return fmt.Errorf("failed to initialize IPFS HTTP client")
+	}
+
+	// Setup libp2p host. The IPFS node owns the configured ports, so this
+	// host listens on the same interfaces with ports picked by the system.
+	h, err := setupLibp2p(ctx, privKey, withEphemeralPorts(cfg.ListenAddrs), psk)
+	if err != nil {
+		return fmt.Errorf("failed to create libp2p host: %w", err)
+	}
+	n.host = h
+
+	// Print peer addresses
+	var addrStrings []string
+	for _, addr := range h.Addrs() {
+		addrStrings = append(addrStrings, fmt.Sprintf("%s/p2p/%s", addr.String(), h.ID().String()))
+	}
+	n.log.Info("peer addresses", "addrs", addrStrings)
+
+	// Create OrbitDB instance
+	orbit, err := orbitdb.NewOrbitDB(ctx, api, &orbitdb.NewOrbitDBOptions{
+		Directory: &orbitDBDir,
+		Logger:    zapLogger(cfg),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create OrbitDB instance: %w", err)
+	}
+	n.orbit = orbit
+
+	connectBootstrapPeers(ctx, n.log, ipfsNode.PeerHost, bootstrap)
+
+	// Keep the explicitly configured peers connected, so that a joining
+	// node finds a replica holding the heads
+	if len(cfg.Peers) > 0 {
+		peering, err := newPeeringService(cfg.logger.With("component", "peering"), ipfsNode.PeerHost, cfg.Peers)
+		if err != nil {
+			return err
+		}
+		peering.Start(n.ctx, &n.wg)
+		n.peering = peering
+	}
+
+	// Import a snapshot before opening the database, so that its manifest
+	// and log are found locally without any peer
+	if cfg.snapshot != "" {
+		snapshot, err := importSnapshot(ctx, n.log, ipfsNode, cfg.snapshot)
+		if err != nil {
+			return err
+		}
+		n.snapshot = snapshot
+		if cfg.DBAddress == "" {
+			cfg.DBAddress = snapshot.Address
+		} else if cfg.DBAddress != snapshot.Address {
+			return fmt.Errorf("snapshot %s holds %s, not %s", cfg.snapshot, snapshot.Address, cfg.DBAddress)
+		}
+	}
+
+	db, err := openDatabase(ctx, n.log, orbit, cfg.DBAddress, &cfg.AccessControl, orbitDBDir)
+	if err != nil {
+		return err
+	}
+	n.db = db
+
+	// The adapter keeps its own document index, updated from the store
+	// events from now on
+	adapter, err := nostrstore.NewOrbitDBAdapter(db)
+	if err != nil {
+		return fmt.Errorf("failed to create adapter: %w", err)
+	}
+	n.adapter = adapter
+
+	// Track replication before loading so that heads exchanged with peers
+	// while the local entries are replayed are not missed. Entries covered
+	// by an index snapshot are not loaded, so ask the adapter whether a
+	// head is present.
+	repl, err := nostrstore.NewReplicationTracker(n.ctx, orbit, db, adapter.HasEntry)
+	if err != nil {
+		return fmt.Errorf("failed to track replication: %w", err)
+	}
+	n.repl = repl
+	events, unsubscribe := repl.Subscribe()
+	n.goBackground(func(ctx context.Context) {
+		defer unsubscribe()
+		logReplication(ctx, cfg.logger.With("component", "replication"), events)
+	})
+
+	if err := n.registerMetrics(); err != nil {
+		return fmt.Errorf("failed to register metrics: %w", err)
+	}
+
+	// Replay the entries already stored locally. With an index snapshot
+	// only the entries written since it was taken need to be loaded; the
+	// adapter derives how many from the entries the snapshot covers.
+	amount := -1
+	if cfg.Snapshot.Interval > 0 {
+		count, err := adapter.LoadIndexSnapshot(ctx, n.indexSnapshotPath())
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			n.log.Warn("ignoring index snapshot, replaying the whole log", "err", err)
+		default:
+			n.log.Info("loaded index snapshot", "load_entries", count)
+			// 0 would make Load replay the whole log
+			amount = max(count, 1)
+		}
+	}
+	loadCtx, span := tracer.Start(ctx, "orbitdb.db.Load", trace.WithAttributes(attribute.Int("orbitdb.amount", amount)))
+	err = db.Load(loadCtx, amount)
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("failed to load database: %w", err)
+	}
+	if n.snapshot != nil {
+		count, err := nostrstore.LoadCARHeads(ctx, db, adapter.HasEntry, n.snapshot.Heads)
+		if err != nil {
+			return fmt.Errorf("failed to load snapshot heads: %w", err)
+		}
+		n.log.Info("replicating heads from snapshot", "heads", count)
+	}
+
+	// Answer direct heads requests and ask every peer speaking the protocol
+	// for its heads on connect, in addition to the pubsub gossip
+	heads, err := nostrstore.NewHeadsExchange(n.ctx, ipfsNode.PeerHost, orbit)
+	if err != nil {
+		return fmt.Errorf("failed to start heads exchange: %w", err)
+	}
+	n.heads = heads
+	heads.Register(db, adapter.HasEntry)
+
+	if cfg.DBAddress != "" && cfg.WaitSync > 0 {
+		waitSync(ctx, n.log, repl, cfg.WaitSync)
+	}
+
+	// Compare the event set with peers and pull what the log sync missed
+	n.recon = nostrstore.NewReconciler(n.ctx, ipfsNode.PeerHost, &nostrstore.ReconcileOptions{
+		BucketSize: int64(cfg.Reconcile.BucketSize / time.Second),
+		Accept:     cfg.Relay.Policies.check,
+	})
+	n.recon.Register(n.adapter)
+	if cfg.Reconcile.Interval > 0 {
+		n.recon.Start(cfg.Reconcile.Interval)
+	}
+
+	if cfg.Snapshot.Interval > 0 {
+		n.goBackground(func(ctx context.Context) {
+			ticker := time.NewTicker(cfg.Snapshot.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if err := adapter.SaveIndexSnapshot(n.indexSnapshotPath()); err != nil {
+						n.log.Error("failed to save index snapshot", "err", err)
+					}
+				}
+			}
+		})
+	}
+
+	// Publish the events written here or replicated from peers to the
+	// configured external relays. The mirror is not stopped with the other
+	// background tasks, so that Close can flush it once writes have stopped.
+	if len(cfg.Mirror.Relays) > 0 {
+		filters, err := cfg.Mirror.filters()
+		if err != nil {
+			return fmt.Errorf("invalid mirror filter: %w", err)
+		}
+		mirror, err := nostrstore.NewMirror(context.WithoutCancel(n.ctx), n.adapter, &nostrstore.MirrorOptions{
+			Relays:      cfg.Mirror.Relays,
+			Filters:     filters,
+			CursorFile:  filepath.Join(cfg.DataDir, "mirror-cursors.json"),
+			QueueSize:   cfg.Mirror.QueueSize,
+			MaxAttempts: cfg.Mirror.MaxAttempts,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to start mirroring: %w", err)
+		}
+		n.mirror = mirror
+	}
+
+	if cfg.Discovery.TopicPeers {
+		n.goBackground(func(ctx context.Context) {
+			discoverTopicPeers(ctx, cfg.logger.With("component", "discovery"), ipfsNode, db.Address().String(), cfg.Discovery.Interval, &n.wg)
+		})
+	}
+	return nil
+}
+
+// indexSnapshotPath is the file holding the document index snapshot.
+func (n *node) indexSnapshotPath() string {
+	return filepath.Join(n.cfg.DataDir, "index-snapshot.json")
+}
+
+// importSnapshot copies the blocks of the CAR file at path into the
+// blockstore of the IPFS node.
+func importSnapshot(ctx context.Context, logger *slog.Logger, ipfsNode *core.IpfsNode, path string) (*nostrstore.CARSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	snapshot, err := nostrstore.ImportCAR(ctx, f, ipfsNode.Blockstore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import snapshot %s: %w", path, err)
+	}
+	logger.Info("imported snapshot", "path", path, "blocks", snapshot.Blocks, "address", snapshot.Address)
+	return snapshot, nil
+}
+
+// goBackground runs fn in a goroutine whose context is cancelled, and
+// which is waited for, when the node is closed.
+func (n *node) goBackground(fn func(ctx context.Context)) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		fn(n.ctx)
+	}()
+}
+
+// waitSync blocks until the heads of at least one peer have been loaded or
+// timeout elapses. A timeout is logged; the node starts either way.
+func waitSync(ctx context.Context, logger *slog.Logger, repl *nostrstore.ReplicationTracker, timeout time.Duration) {
+	logger.Info("waiting for peers to sync", "timeout", timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	loaded, err := repl.WaitSynced(ctx)
+	if err != nil {
+		logger.Warn("not synced, continuing", "timeout", timeout, "loaded", loaded, "err", err)
+		return
+	}
+	logger.Info("synced with peers", "loaded", loaded)
+}
+
+// logReplication logs the changes of the sync state reported on events
+// until ctx is done or the channel is closed.
+func logReplication(ctx context.Context, logger *slog.Logger, events <-chan nostrstore.ReplicationEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt, ok := <-events:
+			if !ok {
+				return
+			}
+			switch evt.Type {
+			case nostrstore.ReplicationSynced:
+				logger.Info("replication synced", "progress", evt.Progress, "max", evt.Max)
+			case nostrstore.ReplicationUnsynced:
+				logger.Info("replication behind peers, catching up")
+			case nostrstore.ReplicationLoaded:
+				logger.Info("replicated entries", "entries", evt.Entries)
+			}
+		}
+	}
+}
+
+// openDatabase connects to dbAddress, or creates the default document store
+// guarded by the given access controller when dbAddress is empty.
+func openDatabase(ctx context.Context, logger *slog.Logger, orbit iface.OrbitDB, dbAddress string, ac *accessControlConfig, orbitDBDir string) (iface.DocumentStore, error) {
+	create := true
+
+	if dbAddress != "" {
+		// Connect to existing database
+		logger.Info("connecting to database", "address", dbAddress)
+		dbInstance, err := orbit.Open(ctx, dbAddress, &orbitdb.CreateDBOptions{
+			Directory: &orbitDBDir,
+			Create:    &create,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+		db, ok := dbInstance.(iface.DocumentStore)
+		if !ok {
+			dbInstance.Close()
+			return nil, fmt.Errorf("database %s is a %s store, not a document store", dbAddress, dbInstance.Type())
+		}
+		return db, nil
+	}
+
+	// Create new database with the configured write access
+	logger.Info("creating new database")
+	dbOptions := &orbitdb.CreateDBOptions{
+		AccessController: &accesscontroller.CreateAccessControllerOptions{
+			Type: ac.Type,
+			Access: map[string][]string{
+				"write": ac.Write,
+			},
+		},
+		Directory: &orbitDBDir,
+		Create:    &create,
+	}
+
+	db, err := orbit.Docs(ctx, defaultDBName, dbOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database: %w", err)
+	}
+	logger.Info("database created", "address", db.Address().String())
+	return db, nil
+}
+
+// connectBootstrapPeers dials the given peers in the background. Failures
+// are logged; the node keeps running without them.
+func connectBootstrapPeers(ctx context.Context, logger *slog.Logger, h host.Host, addrs []string) {
+	for _, addr := range addrs {
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			logger.Warn("invalid bootstrap peer", "addr", addr, "err", err)
+			continue
+		}
+		go func(info peer.AddrInfo) {
+			dialCtx, cancel := context.WithTimeout(ctx, bootstrapDialTimeout)
+			defer cancel()
+			if err := h.Connect(dialCtx, info); err != nil {
+				logger.Warn("failed to connect to bootstrap peer", "peer", info.ID, "err", err)
+				return
+			}
+			logger.Info("connected to bootstrap peer", "peer", info.ID)
+		}(*info)
+	}
+}
+
+// shutdown closes n within timeout.
+func (n *node) shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return n.Close(ctx)
+}
+
+// Close stops accepting writes, waits for pending replication to be
+// flushed, then closes the store, OrbitDB, the IPFS node and the libp2p
+// host in that order. It gives up once ctx is done; components that have
+// not been closed by then are abandoned.
+func (n *node) Close(ctx context.Context) error {
+	if n.cancel != nil {
+		n.cancel()
+	}
+	if n.grpc != nil {
+		n.grpcService.Close()
+		if err := closeWithContext(ctx, func() error {
+			n.grpc.GracefulStop()
+			return nil
+		}); err != nil {
+			n.grpc.Stop()
+		}
+	}
+	if n.api != nil {
+		if err := n.api.Shutdown(ctx); err != nil {
+			n.log.Error("failed to stop admin API", "err", err)
+		}
+	}
+	if n.http != nil {
+		if err := n.http.Shutdown(ctx); err != nil {
+			n.log.Error("failed to stop HTTP server", "err", err)
+		}
+	}
+	if n.peering != nil {
+		n.peering.Stop()
+	}
+	if err := closeWithContext(ctx, func() error {
+		n.wg.Wait()
+		return nil
+	}); err != nil {
+		n.log.Error("background tasks did not stop", "err", err)
+	}
+
+	if n.recon != nil {
+		n.recon.Close()
+	}
+	if n.heads != nil {
+		n.heads.Close()
+	}
+	if n.repl != nil {
+		n.repl.Close()
+	}
+
+	if n.adapter != nil {
+		n.log.Info("refusing new writes")
+		n.adapter.Close()
+	}
+
+	if n.db != nil {
+		n.log.Info("flushing pending replication")
+		if err := waitReplicationIdle(ctx, n.db); err != nil {
+			n.log.Warn("pending replication not flushed", "err", err)
+		}
+	}
+
+	if n.adapter != nil && n.cfg.Snapshot.Interval > 0 {
+		n.log.Info("saving index snapshot")
+		if err := n.adapter.SaveIndexSnapshot(n.indexSnapshotPath()); err != nil {
+			n.log.Error("failed to save index snapshot", "err", err)
+		}
+	}
+
+	if n.mirror != nil {
+		n.log.Info("flushing events to mirrored relays")
+		if err := n.mirror.Flush(ctx); err != nil {
+			n.log.Warn("mirrored relays not flushed, resuming from the cursors next time", "err", err)
+		}
+		if err := n.mirror.Close(); err != nil {
+			n.log.Error("failed to save mirror cursors", "err", err)
+		}
+	}
+
+	var steps []closeStep
+	if n.db != nil {
+		steps = append(steps, closeStep{"store", n.db.Close})
+	}
+	if n.orbit != nil {
+		steps = append(steps, closeStep{"OrbitDB", n.orbit.Close})
+	}
+	if n.ipfs != nil {
+		steps = append(steps, closeStep{"IPFS node", n.ipfs.Close})
+	}
+	if n.host != nil {
+		steps = append(steps, closeStep{"libp2p host", n.host.Close})
+	}
+
+	var errs []error
+	for _, step := range steps {
+		n.log.Info("closing", "step", step.name)
+		if err := closeWithContext(ctx, step.close); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close %s: %w", step.name, err))
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+
+	if n.stopTracing != nil {
+		if err := n.stopTracing(ctx); err != nil {
+			n.log.Error("failed to flush traces", "err", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// closeStep is a single component to close during shutdown.
+type closeStep struct {
+	name  string
+	close func() error
+}
+
+// closeWithContext runs closeFn and returns its error, or ctx.Err() if ctx
+// is done first.
+func closeWithContext(ctx context.Context, closeFn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- closeFn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitEntries blocks until every entry in hashes is present locally
+// according to has, or until ctx is done.
+func waitEntries(ctx context.Context, has func(cid.Cid) bool, hashes []cid.Cid) error {
+	ticker := time.NewTicker(replicationPollInterval)
+	defer ticker.Stop()
+
+	for _, c := range hashes {
+		for {
+			if has(c) {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+		}
+	}
+	return nil
+}
+
+// waitReplicationIdle blocks until the replicator of db has no queued
+// entries and has loaded everything it was asked to, or until ctx is done.
+func waitReplicationIdle(ctx context.Context, db iface.Store) error {
+	ticker := time.NewTicker(replicationPollInterval)
+	defer ticker.Stop()
+
+	for {
+		status := db.ReplicationStatus()
+		if len(db.Replicator().GetQueue()) == 0 && status.GetProgress() >= status.GetMax() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
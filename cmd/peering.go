@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+)
+
+const (
+	// peeringTag protects connections to configured peers from the
+	// connection manager.
+	peeringTag = "orbitdb-peering"
+
+	peeringMinBackoff = time.Second
+	peeringMaxBackoff = 5 * time.Minute
+)
+
+// peerState is the connection state of an explicitly configured peer.
+type peerState string
+
+const (
+	peerConnecting peerState = "connecting"
+	peerConnected  peerState = "connected"
+	peerBackoff    peerState = "backoff"
+)
+
+// peerStatus reports the state of one configured peer.
+type peerStatus struct {
+	ID             peer.ID   `json:"id"`
+	State          peerState `json:"state"`
+	Attempts       int       `json:"attempts"`
+	LastError      string    `json:"last_error,omitempty"`
+	NextRetry      time.Time `json:"next_retry,omitempty"`
+	ConnectedSince time.Time `json:"connected_since,omitempty"`
+}
+
+// peeringService keeps the node connected to a fixed set of peers. Each
+// peer is dialed at startup and redialed with exponential backoff whenever
+// its last connection drops.
+type peeringService struct {
+	host  host.Host
+	log   *slog.Logger
+	peers map[peer.ID]*peeringPeer
+
+	notifee *network.NotifyBundle
+}
+
+// peeringPeer is the state kept for one configured peer.
+type peeringPeer struct {
+	info peer.AddrInfo
+
+	// disconnected is signalled when the last connection to the peer closes.
+	disconnected chan struct{}
+
+	mu     sync.Mutex
+	status peerStatus
+}
+
+// newPeeringService parses addrs, multiaddrs ending in /p2p/<peer ID>, and
+// returns a service for them. Several addresses of one peer are merged.
+func newPeeringService(logger *slog.Logger, h host.Host, addrs []string) (*peeringService, error) {
+	infos := make(map[peer.ID]*peer.AddrInfo)
+	for _, addr := range addrs {
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer %s: %w", addr, err)
+		}
+		if existing, ok := infos[info.ID]; ok {
+			existing.Addrs = append(existing.Addrs, info.Addrs...)
+			continue
+		}
+		infos[info.ID] = info
+	}
+
+	s := &peeringService{
+		host:  h,
+		log:   logger,
+		peers: make(map[peer.ID]*peeringPeer, len(infos)),
+	}
+	for id, info := range infos {
+		s.peers[id] = &peeringPeer{
+			info:         *info,
+			disconnected: make(chan struct{}, 1),
+			status:       peerStatus{ID: id, State: peerConnecting},
+		}
+	}
+	return s, nil
+}
+
+// Start protects the peers and keeps them connected until ctx is done.
+// The goroutines it starts are tracked by wg.
+func (s *peeringService) Start(ctx context.Context, wg *sync.WaitGroup) {
+	s.notifee = &network.NotifyBundle{
+		DisconnectedF: func(net network.Network, conn network.Conn) {
+			p, ok := s.peers[conn.RemotePeer()]
+			if !ok || net.Connectedness(p.info.ID) == network.Connected {
+				return
+			}
+			select {
+			case p.disconnected <- struct{}{}:
+			default:
+			}
+		},
+	}
+	s.host.Network().Notify(s.notifee)
+
+	for _, p := range s.peers {
+		s.host.Peerstore().AddAddrs(p.info.ID, p.info.Addrs, peerstore.PermanentAddrTTL)
+		s.host.ConnManager().Protect(p.info.ID, peeringTag)
+
+		wg.Add(1)
+		go func(p *peeringPeer) {
+			defer wg.Done()
+			s.maintain(ctx, p)
+		}(p)
+	}
+}
+
+// Stop unregisters the service from the host and lifts the protection of
+// the peers. The goroutines stop with the context given to Start.
+func (s *peeringService) Stop() {
+	if s.notifee != nil {
+		s.host.Network().StopNotify(s.notifee)
+	}
+	for id := range s.peers {
+		s.host.ConnManager().Unprotect(id, peeringTag)
+	}
+}
+
+// Statuses returns the state of every configured peer, sorted by ID.
+func (s *peeringService) Statuses() []peerStatus {
+	statuses := make([]peerStatus, 0, len(s.peers))
+	for _, p := range s.peers {
+		p.mu.Lock()
+		statuses = append(statuses, p.status)
+		p.mu.Unlock()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// maintain dials p, waits for the connection to drop and dials again,
+// backing off exponentially after failed attempts.
+func (s *peeringService) maintain(ctx context.Context, p *peeringPeer) {
+	for {
+		p.update(func(st *peerStatus) {
+			st.State = peerConnecting
+			st.NextRetry = time.Time{}
+		})
+
+		dialCtx, cancel := context.WithTimeout(ctx, peerDialTimeout)
+		err := s.host.Connect(dialCtx, p.info)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			p.update(func(st *peerStatus) {
+				st.State = peerConnected
+				st.Attempts = 0
+				st.LastError = ""
+				st.ConnectedSince = time.Now()
+			})
+			s.log.Info("connected to peer", "peer", p.info.ID)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.disconnected:
+			}
+			s.log.Warn("lost connection to peer, redialing", "peer", p.info.ID)
+			continue
+		}
+
+		var delay time.Duration
+		p.update(func(st *peerStatus) {
+			st.Attempts++
+			st.LastError = err.Error()
+			st.ConnectedSince = time.Time{}
+			delay = peeringBackoff(st.Attempts)
+			st.State = peerBackoff
+			st.NextRetry = time.Now().Add(delay)
+		})
+		s.log.Warn("failed to connect to peer", "peer", p.info.ID, "retry_in", delay.Round(time.Second), "err", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (p *peeringPeer) update(fn func(st *peerStatus)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn(&p.status)
+}
+
+// peeringBackoff returns the delay before retrying after the given number
+// of consecutive failed attempts: doubling from peeringMinBackoff up to
+// peeringMaxBackoff, with up to 20% jitter so that nodes restarted together
+// do not redial in lockstep.
+func peeringBackoff(attempts int) time.Duration {
+	delay := peeringMaxBackoff
+	if attempts < 20 {
+		delay = peeringMinBackoff << (attempts - 1)
+		if delay > peeringMaxBackoff {
+			delay = peeringMaxBackoff
+		}
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay - jitter
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// swarmKeyFile is the name of the swarm key looked up in the data
+// directory when swarm.key_file is not set.
+const swarmKeyFile = "swarm.key"
+
+// swarmKey is a loaded pre-shared key of a private swarm.
+type swarmKey struct {
+	// raw is the file content, in the format kubo expects in a repo.
+	raw []byte
+	psk pnet.PSK
+}
+
+// loadSwarmKey reads the swarm key configured in cfg. It returns nil when no
+// key is present and the swarm is not required to be private.
+func loadSwarmKey(cfg *swarmConfig) (*swarmKey, error) {
+	raw, err := os.ReadFile(cfg.KeyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		if cfg.Private {
+			return nil, fmt.Errorf("swarm.private is set but the swarm key %s does not exist", cfg.KeyFile)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read swarm key: %w", err)
+	}
+
+	psk, err := pnet.DecodeV1PSK(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid swarm key %s: %w", cfg.KeyFile, err)
+	}
+	return &swarmKey{raw: raw, psk: psk}, nil
+}
+
+// bootstrapPeers returns the peers the node bootstraps from: the configured
+// ones, plus the public IPFS bootstrap nodes unless the swarm is private.
+func bootstrapPeers(logger *slog.Logger, cfg *nodeConfig, key *swarmKey) []string {
+	peers := append([]string{}, cfg.BootstrapPeers...)
+	if !cfg.Swarm.PublicBootstrap {
+		return peers
+	}
+	if key != nil {
+		logger.Info("private swarm: not bootstrapping from public IPFS nodes")
+		return peers
+	}
+	return append(peers, DefaultBootstrapPeers...)
+}
@@ -0,0 +1,242 @@
+package orbitdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/utils"
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+)
+
+// carImportBatch 导入时每批写入块存储的块数
+const carImportBatch = 256
+
+// CARSnapshot 导入的 CAR 快照
+type CARSnapshot struct {
+	// Address 快照中数据库的地址
+	Address string `json:"address"`
+	// Manifest 数据库 manifest 的 CID，即 CAR 的第一个根
+	Manifest cid.Cid `json:"manifest"`
+	// Heads 导出时日志的 heads，即 CAR 的其余根
+	Heads []cid.Cid `json:"heads"`
+	// Blocks 写入块存储的块数
+	Blocks int `json:"blocks"`
+}
+
+// ExportCAR 将 db 的完整日志写成 CAR 文件：数据库 manifest、访问控制器的 manifest
+// 及其引用的块，以及从 heads 可达的全部条目。CAR 的根依次为 manifest 和当前的 heads。
+// v1 为 false 时写 CARv2，此时 w 必须实现 io.WriterAt；否则以流的方式写 CARv1。
+// 块从 bs 读取，不会访问网络。orbitdb 类型的访问控制器只导出其 manifest。
+// 返回写出的块数。
+func ExportCAR(ctx context.Context, w io.Writer, bs blockstore.Blockstore, db iface.Store, v1 bool) (int, error) {
+	manifest := db.Address().GetRoot()
+	roots := []cid.Cid{manifest}
+	for _, head := range db.OpLog().Heads().Slice() {
+		roots = append(roots, head.GetHash())
+	}
+
+	var opts []carv2.Option
+	if v1 {
+		opts = append(opts, carv2.WriteAsCarV1(true))
+	}
+	car, err := storage.NewWritable(w, roots, opts...)
+	if err != nil {
+		return 0, fmt.Errorf("无法创建 CAR: %w", err)
+	}
+
+	x := &carExporter{ctx: ctx, bs: bs, car: car, seen: make(map[cid.Cid]bool)}
+
+	// manifest 以字符串引用访问控制器，需要单独解析
+	if err := x.putTree(manifest); err != nil {
+		return x.count, err
+	}
+	ac, err := readManifestAccessController(ctx, bs, manifest)
+	if err != nil {
+		return x.count, err
+	}
+	if err := x.putTree(ac); err != nil {
+		return x.count, err
+	}
+
+	// 启动时可能只加载了部分日志，从 heads 沿链接遍历块存储中的全部条目
+	for _, head := range roots[1:] {
+		if err := x.putTree(head); err != nil {
+			return x.count, err
+		}
+	}
+
+	if err := car.Finalize(); err != nil {
+		return x.count, fmt.Errorf("写入 CAR 失败: %w", err)
+	}
+	return x.count, nil
+}
+
+// carExporter 将块从块存储复制到 CAR，每个块只写一次
+type carExporter struct {
+	ctx   context.Context
+	bs    blockstore.Blockstore
+	car   storage.WritableCar
+	seen  map[cid.Cid]bool
+	count int
+}
+
+// putBlock 写入块 c 并返回它，已写过时返回 nil
+func (x *carExporter) putBlock(c cid.Cid) (blocks.Block, error) {
+	if x.seen[c] {
+		return nil, nil
+	}
+	if err := x.ctx.Err(); err != nil {
+		return nil, err
+	}
+	blk, err := x.bs.Get(x.ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("读取块 %s 失败: %w", c, err)
+	}
+	if err := x.car.Put(x.ctx, c.KeyString(), blk.RawData()); err != nil {
+		return nil, fmt.Errorf("写入块 %s 失败: %w", c, err)
+	}
+	x.seen[c] = true
+	x.count++
+	return blk, nil
+}
+
+// putTree 写入块 c 以及它通过 CBOR 链接引用的全部块。
+// 日志可能很长，用栈而不是递归遍历。
+func (x *carExporter) putTree(c cid.Cid) error {
+	stack := []cid.Cid{c}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		blk, err := x.putBlock(c)
+		if err != nil {
+			return err
+		}
+		if blk == nil || c.Type() != cid.DagCBOR {
+			continue
+		}
+		node, err := cbornode.DecodeBlock(blk)
+		if err != nil {
+			return fmt.Errorf("无法解析块 %s: %w", c, err)
+		}
+		for _, link := range node.Links() {
+			stack = append(stack, link.Cid)
+		}
+	}
+	return nil
+}
+
+// ReadManifest 从块存储读取数据库 manifest
+func ReadManifest(ctx context.Context, bs blockstore.Blockstore, c cid.Cid) (*utils.Manifest, error) {
+	blk, err := bs.Get(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("读取数据库 manifest 失败: %w", err)
+	}
+	manifest := &utils.Manifest{}
+	if err := cbornode.DecodeInto(blk.RawData(), manifest); err != nil {
+		return nil, fmt.Errorf("数据库 manifest 格式错误: %w", err)
+	}
+	return manifest, nil
+}
+
+// readManifestAccessController 返回数据库 manifest 引用的访问控制器 manifest 的 CID
+func readManifestAccessController(ctx context.Context, bs blockstore.Blockstore, c cid.Cid) (cid.Cid, error) {
+	manifest, err := ReadManifest(ctx, bs, c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	ac, err := cid.Decode(strings.TrimPrefix(manifest.AccessController, "/ipfs/"))
+	if err != nil {
+		return cid.Undef, fmt.Errorf("访问控制器地址 %q 无效: %w", manifest.AccessController, err)
+	}
+	return ac, nil
+}
+
+// ImportCAR 读取 ExportCAR 写出的 CARv1 或 CARv2，校验每个块的哈希后写入 bs。
+// 之后即可不经网络打开快照中的数据库，再用 LoadCARHeads 载入快照的日志。
+func ImportCAR(ctx context.Context, r io.Reader, bs blockstore.Blockstore) (*CARSnapshot, error) {
+	br, err := carv2.NewBlockReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取 CAR: %w", err)
+	}
+	if len(br.Roots) == 0 {
+		return nil, fmt.Errorf("CAR 中没有根")
+	}
+	snap := &CARSnapshot{Manifest: br.Roots[0], Heads: br.Roots[1:]}
+
+	batch := make([]blocks.Block, 0, carImportBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := bs.PutMany(ctx, batch); err != nil {
+			return fmt.Errorf("写入块存储失败: %w", err)
+		}
+		snap.Blocks += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blk, err := br.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("读取第 %d 个块失败: %w", snap.Blocks+len(batch)+1, err)
+		}
+		batch = append(batch, blk)
+		if len(batch) == carImportBatch {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	manifest, err := ReadManifest(ctx, bs, snap.Manifest)
+	if err != nil {
+		return nil, fmt.Errorf("CAR 的第一个根不是数据库 manifest: %w", err)
+	}
+	snap.Address = path.Join("/orbitdb", snap.Manifest.String(), manifest.Name)
+	return snap, nil
+}
+
+// LoadCARHeads 将快照的 heads 交给 db.Sync，由它校验写权限后在后台复制整个日志。
+// 先用 ImportCAR 导入快照后，全部条目都在本地块存储中，复制不需要网络。
+// has 报告条目是否已在本地，为 nil 时只检查已加载的日志。返回本地尚没有的 heads 数量。
+func LoadCARHeads(ctx context.Context, db iface.Store, has func(cid.Cid) bool, heads []cid.Cid) (int, error) {
+	has = entryCheck(db, has)
+	missing := make([]ipfslog.Entry, 0, len(heads))
+	for _, c := range heads {
+		if has(c) {
+			continue
+		}
+		e, err := readEntry(ctx, db, c)
+		if err != nil {
+			return 0, err
+		}
+		missing = append(missing, e)
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+	if err := db.Sync(ctx, missing); err != nil {
+		return 0, err
+	}
+	return len(missing), nil
+}
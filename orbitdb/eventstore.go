@@ -0,0 +1,164 @@
+package orbitdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/nbd-wtf/go-nostr"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OrbitDBAdapter 和 MemoryStore 实现 github.com/fiatjaf/eventstore 的 Store 和 Counter，
+// 可以直接作为 khatru 中继的后端：
+//
+//	relay.StoreEvent = append(relay.StoreEvent, adapter.SaveEvent)
+//	relay.QueryEvents = append(relay.QueryEvents, adapter.QueryEvents)
+//	relay.CountEvents = append(relay.CountEvents, adapter.CountEvents)
+//	relay.DeleteEvent = append(relay.DeleteEvent, adapter.DeleteEvent)
+//	relay.ReplaceEvent = append(relay.ReplaceEvent, adapter.ReplaceEvent)
+var (
+	_ eventstore.Store   = (*OrbitDBAdapter)(nil)
+	_ eventstore.Counter = (*OrbitDBAdapter)(nil)
+	_ eventstore.Store   = (*MemoryStore)(nil)
+	_ eventstore.Counter = (*MemoryStore)(nil)
+)
+
+var (
+	// ErrNotReplaceable ReplaceEvent 的事件类型不是 NIP-01 定义的可替换类型时返回
+	ErrNotReplaceable = errors.New("事件类型不可替换")
+	// ErrNewerExists ReplaceEvent 的地址下已有不比它旧的事件时返回，事件没有被保存。
+	// 它就是 eventstore.ErrDupEvent，khatru 据此回复 OK 而不广播该事件
+	ErrNewerExists = eventstore.ErrDupEvent
+)
+
+// Init 满足 eventstore.Store。NewOrbitDBAdapter 返回时适配器已经可用，适配器关闭后返回 ErrClosed
+func (a *OrbitDBAdapter) Init() error {
+	if a.Closed() {
+		return ErrClosed
+	}
+	return nil
+}
+
+// ReplaceEvent 按 NIP-01 保存可替换事件：同一作者、类型（以及 d 标签）下只保留
+// created_at 最新的事件，时间相同时保留 ID 较小的事件。已有不比它旧的事件时不保存，
+// 返回 ErrNewerExists；非可替换类型返回 ErrNotReplaceable
+func (a *OrbitDBAdapter) ReplaceEvent(ctx context.Context, event *nostr.Event) (err error) {
+	if event == nil {
+		return fmt.Errorf("事件不能为空")
+	}
+	if !replaceable(event.Kind) {
+		return fmt.Errorf("%w: %d", ErrNotReplaceable, event.Kind)
+	}
+	ctx, span := tracer.Start(ctx, "orbitdb.ReplaceEvent", trace.WithAttributes(eventAttributes(event)...))
+	defer func() { endSpan(span, err) }()
+
+	a.replaceMu.Lock()
+	defer a.replaceMu.Unlock()
+
+	previous, err := a.matchingEvents(ctx, addressFilter(event))
+	if err != nil {
+		return err
+	}
+	older, newer := splitOlder(previous, event)
+	if !newer {
+		// 先保存新事件再删除旧事件，保存失败时不丢失已有的事件
+		if err := a.SaveEvent(ctx, event); err != nil {
+			return err
+		}
+	}
+	for _, evt := range older {
+		if err := a.DeleteEvent(ctx, evt); err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("删除被替换的事件 %s 失败: %w", evt.ID, err)
+		}
+	}
+	if newer {
+		return ErrNewerExists
+	}
+	return nil
+}
+
+// Init 满足 eventstore.Store，内存存储不需要初始化
+func (m *MemoryStore) Init() error {
+	return nil
+}
+
+// ReplaceEvent 与 OrbitDBAdapter.ReplaceEvent 相同
+func (m *MemoryStore) ReplaceEvent(ctx context.Context, event *nostr.Event) error {
+	if event == nil {
+		return fmt.Errorf("事件不能为空")
+	}
+	if !replaceable(event.Kind) {
+		return fmt.Errorf("%w: %d", ErrNotReplaceable, event.Kind)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// 与 SaveEvent 分开加锁会让并发的替换交错，这里持锁完成查找、保存和删除
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	var previous []*nostr.Event
+	filter := addressFilter(event)
+	for _, evt := range m.events {
+		if filter.Matches(evt) {
+			previous = append(previous, evt)
+		}
+	}
+	older, newer := splitOlder(previous, event)
+	if !newer {
+		m.save(event)
+	}
+	for _, evt := range older {
+		delete(m.events, evt.ID)
+	}
+	if newer {
+		return ErrNewerExists
+	}
+	return nil
+}
+
+// replaceable 报告 kind 是否为 NIP-01 定义的可替换或可寻址类型
+func replaceable(kind int) bool {
+	return kind == 0 || kind == 3 || (kind >= 10000 && kind < 20000) || addressable(kind)
+}
+
+// addressable 报告 kind 是否为以 d 标签区分地址的可寻址类型
+func addressable(kind int) bool {
+	return kind >= 30000 && kind < 40000
+}
+
+// dTag 返回事件第一个 d 标签的值，没有时为空字符串
+func dTag(event *nostr.Event) string {
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == "d" {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// addressFilter 返回与 event 同一作者和类型的事件的过滤器。
+// 可寻址类型还要比较 d 标签，由 splitOlder 完成，因为过滤器不能匹配缺少 d 标签的事件
+func addressFilter(event *nostr.Event) nostr.Filter {
+	return nostr.Filter{Authors: []string{event.PubKey}, Kinds: []int{event.Kind}}
+}
+
+// splitOlder 返回 previous 中与 event 地址相同、且比它旧的事件，以及是否有不比它旧的事件
+func splitOlder(previous []*nostr.Event, event *nostr.Event) (older []*nostr.Event, newer bool) {
+	for _, evt := range previous {
+		if addressable(event.Kind) && dTag(evt) != dTag(event) {
+			continue
+		}
+		if evt.CreatedAt < event.CreatedAt || (evt.CreatedAt == event.CreatedAt && evt.ID > event.ID) {
+			older = append(older, evt)
+		} else {
+			newer = true
+		}
+	}
+	return older, newer
+}
@@ -0,0 +1,200 @@
+package orbitdb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nbd-wtf/go-nostr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/maoaixiao1314/orbitdb/orbitdbpb"
+)
+
+// GRPCOptions gRPC 服务的选项
+type GRPCOptions struct {
+	// Accept 在保存前检查通过了 ID 和签名校验的事件，返回错误时拒绝该事件
+	Accept func(evt *nostr.Event) error
+}
+
+// GRPCService 以 gRPC 提供 Store 的读写，实现 orbitdbpb.EventStoreServer
+type GRPCService struct {
+	orbitdbpb.UnimplementedEventStoreServer
+
+	store  Store
+	accept func(evt *nostr.Event) error
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewGRPCService 创建 store 的 gRPC 服务，opts 可以为 nil。
+// store 为 OrbitDBAdapter 时，被拒绝的事件计入它的指标
+func NewGRPCService(store Store, opts *GRPCOptions) *GRPCService {
+	if opts == nil {
+		opts = &GRPCOptions{}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &GRPCService{
+		store:  store,
+		accept: opts.Accept,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Close 结束进行中的订阅，使 grpc.Server.GracefulStop 不必等待它们
+func (g *GRPCService) Close() {
+	g.cancel()
+}
+
+// Register 在 s 上注册 EventStore 服务
+func (g *GRPCService) Register(s grpc.ServiceRegistrar) {
+	orbitdbpb.RegisterEventStoreServer(s, g)
+}
+
+// SaveEvent 校验事件的 ID、签名和 Accept 后保存，存储中已有的事件不再写入
+func (g *GRPCService) SaveEvent(ctx context.Context, req *orbitdbpb.SaveEventRequest) (*orbitdbpb.SaveEventResponse, error) {
+	evt := orbitdbpb.EventFromProto(req.GetEvent())
+	if evt == nil {
+		return nil, status.Error(codes.InvalidArgument, "缺少事件")
+	}
+	if !validEvent(evt) {
+		g.reject(RejectInvalid)
+		return nil, status.Errorf(codes.InvalidArgument, "事件 %s 的 ID 或签名无效", evt.ID)
+	}
+	if g.accept != nil {
+		if err := g.accept(evt); err != nil {
+			g.reject(RejectPolicy)
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+	exists, err := g.hasEvent(ctx, evt.ID)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if exists {
+		// 与 nostr 中继一样，重复的事件视为已保存
+		g.reject(RejectDuplicate)
+		return &orbitdbpb.SaveEventResponse{}, nil
+	}
+	if err := g.store.SaveEvent(ctx, evt); err != nil {
+		return nil, grpcError(err)
+	}
+	return &orbitdbpb.SaveEventResponse{}, nil
+}
+
+// QueryEvents 按从新到旧的顺序发送匹配过滤器的事件，最多 limit 个
+func (g *GRPCService) QueryEvents(req *orbitdbpb.QueryEventsRequest, stream grpc.ServerStreamingServer[orbitdbpb.QueryEventsResponse]) error {
+	ctx := stream.Context()
+	events, err := g.store.QueryEvents(ctx, orbitdbpb.FilterFromProto(req.GetFilter()))
+	if err != nil {
+		return grpcError(err)
+	}
+	for evt := range events {
+		if err := stream.Send(&orbitdbpb.QueryEventsResponse{Event: orbitdbpb.EventToProto(evt)}); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return grpcError(err)
+	}
+	return nil
+}
+
+// CountEvents 返回匹配过滤器的事件数，不受 limit 限制
+func (g *GRPCService) CountEvents(ctx context.Context, req *orbitdbpb.CountEventsRequest) (*orbitdbpb.CountEventsResponse, error) {
+	count, err := g.store.CountEvents(ctx, orbitdbpb.FilterFromProto(req.GetFilter()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &orbitdbpb.CountEventsResponse{Count: count}, nil
+}
+
+// DeleteEvent 删除 ID 为 req.Id 的事件
+func (g *GRPCService) DeleteEvent(ctx context.Context, req *orbitdbpb.DeleteEventRequest) (*orbitdbpb.DeleteEventResponse, error) {
+	if err := g.store.DeleteEvent(ctx, &nostr.Event{ID: req.GetId()}); err != nil {
+		return nil, grpcError(err)
+	}
+	return &orbitdbpb.DeleteEventResponse{}, nil
+}
+
+// Subscribe 发送调用之后写入或复制到存储、且匹配任一过滤器的事件，直到客户端取消。
+// 订阅生效后先发送响应头，客户端收到响应头后再写入的事件不会漏掉
+func (g *GRPCService) Subscribe(req *orbitdbpb.SubscribeRequest, stream grpc.ServerStreamingServer[orbitdbpb.SubscribeResponse]) error {
+	var filters nostr.Filters
+	for _, f := range req.GetFilters() {
+		filters = append(filters, orbitdbpb.FilterFromProto(f))
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	events, err := g.store.Subscribe(ctx, filters)
+	if err != nil {
+		return grpcError(err)
+	}
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-g.ctx.Done():
+			return status.Error(codes.Unavailable, "服务已关闭")
+		case evt, ok := <-events:
+			if !ok {
+				switch {
+				case stream.Context().Err() != nil:
+					return nil
+				case g.closed():
+					return grpcError(ErrClosed)
+				default:
+					// 存储在通道的缓冲已满时结束订阅
+					return status.Error(codes.ResourceExhausted, "客户端读取过慢，订阅已结束")
+				}
+			}
+			if err := stream.Send(&orbitdbpb.SubscribeResponse{Event: orbitdbpb.EventToProto(evt)}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// reject 记录一个被拒绝的事件
+func (g *GRPCService) reject(reason string) {
+	if a, ok := g.store.(*OrbitDBAdapter); ok {
+		a.metrics.Reject(reason)
+	}
+}
+
+// hasEvent 报告存储中是否已有该 ID 的事件
+func (g *GRPCService) hasEvent(ctx context.Context, id string) (bool, error) {
+	if a, ok := g.store.(*OrbitDBAdapter); ok {
+		return a.hasEvent(ctx, id)
+	}
+	count, err := g.store.CountEvents(ctx, nostr.Filter{IDs: []string{id}})
+	return count > 0, err
+}
+
+// closed 报告存储是否已关闭
+func (g *GRPCService) closed() bool {
+	c, ok := g.store.(interface{ Closed() bool })
+	return ok && c.Closed()
+}
+
+// grpcError 将存储的错误转换为 gRPC 状态
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
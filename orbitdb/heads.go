@@ -0,0 +1,344 @@
+package orbitdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-orbit-db/baseorbitdb"
+	"berty.tech/go-orbit-db/iface"
+	cid "github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+)
+
+// HeadsProtocol 直接交换 heads 的 libp2p 协议
+const HeadsProtocol protocol.ID = "/orbitdb/heads/1.0.0"
+
+const (
+	// MaxHeadsEntries 单次请求最多返回的条目数
+	MaxHeadsEntries = 256
+
+	// headsRequestLimit 请求的最大字节数
+	headsRequestLimit = 64 << 10
+	// headsResponseLimit 响应的最大字节数
+	headsResponseLimit = 16 << 20
+	// headsStreamTimeout 单次交换的超时时间
+	headsStreamTimeout = 30 * time.Second
+)
+
+// HeadsRequest 请求某个数据库的 heads，以及可选的一批条目
+type HeadsRequest struct {
+	Address string    `json:"address"`
+	Entries []cid.Cid `json:"entries,omitempty"`
+}
+
+// HeadsResponse 对 HeadsRequest 的应答；本地没有的条目不会出现在 Entries 中
+type HeadsResponse struct {
+	Address string         `json:"address"`
+	Heads   []*entry.Entry `json:"heads,omitempty"`
+	Entries []*entry.Entry `json:"entries,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// HeadsExchange 在 pubsub 广播之外，通过直接的 libp2p 流交换 heads。
+// 对端连接并完成 identify 后会自动向其请求已注册数据库的 heads，
+// 错过的 pubsub 消息因此不会让副本一直分叉到下一次写入。
+type HeadsExchange struct {
+	host    host.Host
+	emitter event.Emitter
+	log     *slog.Logger
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	mu     sync.RWMutex
+	stores map[string]iface.Store
+	// has 注册时给出的判断条目是否在本地的函数
+	has map[string]func(cid.Cid) bool
+}
+
+// NewHeadsExchange 在 h 上注册 HeadsProtocol，直到调用 Close。
+// 收到的 heads 与 pubsub 交换的一样，以 baseorbitdb.EventExchangeHeads 发布到 orbit 的事件总线。
+func NewHeadsExchange(ctx context.Context, h host.Host, orbit iface.OrbitDB) (*HeadsExchange, error) {
+	emitter, err := orbit.EventBus().Emitter(new(baseorbitdb.EventExchangeHeads))
+	if err != nil {
+		return nil, fmt.Errorf("无法创建 heads 事件发送器: %w", err)
+	}
+	sub, err := h.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted),
+		eventbus.Name("orbitdb/heads-exchange"))
+	if err != nil {
+		emitter.Close()
+		return nil, fmt.Errorf("无法订阅 identify 事件: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	x := &HeadsExchange{
+		host:    h,
+		emitter: emitter,
+		log:     componentLogger("heads"),
+		ctx:     ctx,
+		cancel:  cancel,
+		stores:  make(map[string]iface.Store),
+		has:     make(map[string]func(cid.Cid) bool),
+	}
+	h.SetStreamHandler(HeadsProtocol, x.handleStream)
+
+	x.wg.Add(1)
+	go func() {
+		defer x.wg.Done()
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-sub.Out():
+				evt := e.(event.EvtPeerIdentificationCompleted)
+				if slices.Contains(evt.Protocols, HeadsProtocol) {
+					x.syncPeer(evt.Peer)
+				}
+			}
+		}
+	}()
+
+	return x, nil
+}
+
+// Register 开始为 db 应答请求，并向已连接的对端请求它的 heads。
+// has 报告条目是否已在本地，为 nil 时只检查已加载的日志；从索引快照启动时应传入
+// OrbitDBAdapter.HasEntry，快照覆盖而没有加载的条目从块存储读取后应答，也不会再次复制
+func (x *HeadsExchange) Register(db iface.Store, has func(cid.Cid) bool) {
+	x.mu.Lock()
+	x.stores[db.Address().String()] = db
+	x.has[db.Address().String()] = entryCheck(db, has)
+	x.mu.Unlock()
+
+	for _, p := range x.host.Network().Peers() {
+		if ok, _ := x.host.Peerstore().SupportsProtocols(p, HeadsProtocol); len(ok) > 0 {
+			x.syncPeer(p)
+		}
+	}
+}
+
+// Unregister 停止为 db 应答请求
+func (x *HeadsExchange) Unregister(db iface.Store) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.stores, db.Address().String())
+	delete(x.has, db.Address().String())
+}
+
+// Close 移除协议处理器并等待进行中的同步结束
+func (x *HeadsExchange) Close() {
+	x.host.RemoveStreamHandler(HeadsProtocol)
+	x.mu.Lock()
+	x.cancel()
+	x.mu.Unlock()
+	x.wg.Wait()
+	x.emitter.Close()
+}
+
+// Request 向对端 p 请求 address 的 heads，以及 entries 中列出的条目
+func (x *HeadsExchange) Request(ctx context.Context, p peer.ID, address string, entries []cid.Cid) (*HeadsResponse, error) {
+	if len(entries) > MaxHeadsEntries {
+		return nil, fmt.Errorf("单次最多请求 %d 个条目", MaxHeadsEntries)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, headsStreamTimeout)
+	defer cancel()
+
+	s, err := x.host.NewStream(ctx, p, HeadsProtocol)
+	if err != nil {
+		return nil, fmt.Errorf("无法打开到 %s 的流: %w", p, err)
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(s).Encode(&HeadsRequest{Address: address, Entries: entries}); err != nil {
+		s.Reset()
+		return nil, fmt.Errorf("发送请求失败: %w", err)
+	}
+	if err := s.CloseWrite(); err != nil {
+		s.Reset()
+		return nil, fmt.Errorf("发送请求失败: %w", err)
+	}
+
+	resp := &HeadsResponse{}
+	if err := json.NewDecoder(io.LimitReader(s, headsResponseLimit)).Decode(resp); err != nil {
+		s.Reset()
+		return nil, fmt.Errorf("读取应答失败: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("对端 %s 拒绝请求: %s", p, resp.Error)
+	}
+	if resp.Address != address {
+		return nil, fmt.Errorf("对端 %s 应答了错误的地址 %s", p, resp.Address)
+	}
+	return resp, nil
+}
+
+// SyncFrom 向对端 p 请求 db 的 heads 并交给 db.Sync 复制，返回本地尚没有的 heads 数量。
+// 复制在后台进行，不受 ctx 影响，直到 Close。
+func (x *HeadsExchange) SyncFrom(ctx context.Context, p peer.ID, db iface.Store) (int, error) {
+	resp, err := x.Request(ctx, p, db.Address().String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	if err := x.emitter.Emit(baseorbitdb.NewEventExchangeHeads(p, &iface.MessageExchangeHeads{
+		Address: resp.Address,
+		Heads:   resp.Heads,
+	})); err != nil {
+		x.log.Warn("failed to emit heads event", "peer", p, "err", err)
+	}
+
+	n, err := x.sync(db, resp.Heads)
+	if err != nil {
+		return 0, fmt.Errorf("同步 %s 的 heads 失败: %w", p, err)
+	}
+	return n, nil
+}
+
+// FetchEntries 向对端 p 请求 db 中的一批条目，校验后写入本地并复制，
+// 不必再经由 bitswap 逐个取回。返回本地尚没有的条目数量。
+func (x *HeadsExchange) FetchEntries(ctx context.Context, p peer.ID, db iface.Store, entries []cid.Cid) (int, error) {
+	resp, err := x.Request(ctx, p, db.Address().String(), entries)
+	if err != nil {
+		return 0, err
+	}
+	n, err := x.sync(db, resp.Entries)
+	if err != nil {
+		return 0, fmt.Errorf("同步 %s 的条目失败: %w", p, err)
+	}
+	return n, nil
+}
+
+// sync 将本地没有的条目交给 db.Sync，由它校验写权限和哈希后复制
+func (x *HeadsExchange) sync(db iface.Store, entries []*entry.Entry) (int, error) {
+	has := x.entryCheck(db)
+	missing := make([]ipfslog.Entry, 0, len(entries))
+	for _, e := range entries {
+		if e == nil {
+			continue
+		}
+		if has(e.GetHash()) {
+			continue
+		}
+		missing = append(missing, e)
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+	if err := db.Sync(x.ctx, missing); err != nil {
+		return 0, err
+	}
+	return len(missing), nil
+}
+
+// entryCheck 返回注册 db 时给出的 has，没有注册时只检查已加载的日志
+func (x *HeadsExchange) entryCheck(db iface.Store) func(cid.Cid) bool {
+	x.mu.RLock()
+	has := x.has[db.Address().String()]
+	x.mu.RUnlock()
+	return entryCheck(db, has)
+}
+
+// syncPeer 在后台向对端 p 请求所有已注册数据库的 heads
+func (x *HeadsExchange) syncPeer(p peer.ID) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.ctx.Err() != nil {
+		return
+	}
+
+	for _, db := range x.stores {
+		x.wg.Add(1)
+		go func(db iface.Store) {
+			defer x.wg.Done()
+			n, err := x.SyncFrom(x.ctx, p, db)
+			if err != nil {
+				if x.ctx.Err() == nil {
+					x.log.Warn("heads exchange failed", "peer", p, "db", db.Address().String(), "err", err)
+				}
+				return
+			}
+			if n > 0 {
+				x.log.Info("received new heads", "peer", p, "db", db.Address().String(), "heads", n)
+			}
+		}(db)
+	}
+}
+
+// handleStream 应答一个 HeadsRequest
+func (x *HeadsExchange) handleStream(s network.Stream) {
+	defer s.Close()
+	_ = s.SetDeadline(time.Now().Add(headsStreamTimeout))
+
+	req := &HeadsRequest{}
+	if err := json.NewDecoder(io.LimitReader(s, headsRequestLimit)).Decode(req); err != nil {
+		s.Reset()
+		return
+	}
+
+	resp := x.respond(x.ctx, req)
+	if err := json.NewEncoder(s).Encode(resp); err != nil {
+		s.Reset()
+	}
+}
+
+// respond 根据本地日志构造应答。请求的条目没有加载时，如果它在本地，从块存储读取
+func (x *HeadsExchange) respond(ctx context.Context, req *HeadsRequest) *HeadsResponse {
+	resp := &HeadsResponse{Address: req.Address}
+
+	x.mu.RLock()
+	db, ok := x.stores[req.Address]
+	has := x.has[req.Address]
+	x.mu.RUnlock()
+	if !ok {
+		resp.Error = "未知的数据库地址"
+		return resp
+	}
+	if len(req.Entries) > MaxHeadsEntries {
+		resp.Error = fmt.Sprintf("单次最多请求 %d 个条目", MaxHeadsEntries)
+		return resp
+	}
+
+	for _, head := range db.OpLog().Heads().Slice() {
+		if e, ok := head.(*entry.Entry); ok {
+			resp.Heads = append(resp.Heads, e)
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, headsStreamTimeout)
+	defer cancel()
+	for _, c := range req.Entries {
+		got, ok := db.OpLog().Get(c)
+		if !ok && has(c) {
+			// 快照覆盖的条目只在块存储中，has 保证读取时不必经由网络
+			e, err := readEntry(ctx, db, c)
+			if err != nil {
+				x.log.Warn("failed to read covered entry", "db", req.Address, "entry", c, "err", err)
+				continue
+			}
+			got, ok = e, true
+		}
+		if !ok {
+			continue
+		}
+		if e, ok := got.(*entry.Entry); ok {
+			resp.Entries = append(resp.Entries, e)
+		}
+	}
+	return resp
+}
@@ -0,0 +1,390 @@
+package orbitdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultImportPageSize 每次 REQ 请求的事件数
+	DefaultImportPageSize = 500
+
+	// importQueryTimeout 单页查询的超时时间
+	importQueryTimeout = 30 * time.Second
+)
+
+// ImportOptions 从外部中继导入事件的选项
+type ImportOptions struct {
+	// Relays 上游中继的 URL
+	Relays []string
+	// Filters 在每个中继上执行的过滤器，为空时导入全部事件
+	Filters nostr.Filters
+	// PageSize 每页的事件数，为 0 时使用 DefaultImportPageSize
+	PageSize int
+	// CheckpointFile 保存断点的文件，为空时不保存，每次都从头导入
+	CheckpointFile string
+	// Accept 在保存前检查事件，返回错误时跳过该事件
+	Accept func(evt *nostr.Event) error
+}
+
+// ImportStats 从一个中继导入的统计
+type ImportStats struct {
+	Relay      string `json:"relay"`
+	Fetched    int    `json:"fetched"`
+	Saved      int    `json:"saved"`
+	Duplicates int    `json:"duplicates"`
+	Invalid    int    `json:"invalid"`
+	Rejected   int    `json:"rejected"`
+	// Deleted 本地已删除而跳过的事件数
+	Deleted int    `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// importCheckpoint 一个中继上一个过滤器的导入进度。
+// 导入按 until 从新到旧分页回填，直到取不到新的事件为止；完成一轮后，
+// 下一轮只回填比上一轮最新事件更新的部分。
+type importCheckpoint struct {
+	// Until 未完成的一轮中下一页的 until
+	Until *nostr.Timestamp `json:"until,omitempty"`
+	// Pending 未完成的一轮中见到的最新 created_at
+	Pending nostr.Timestamp `json:"pending,omitempty"`
+	// Newest 已完成的各轮中见到的最新 created_at
+	Newest nostr.Timestamp `json:"newest,omitempty"`
+	// Complete 至少完成过一轮
+	Complete bool `json:"complete"`
+}
+
+// importCheckpoints 断点文件的内容，以中继 URL 和过滤器为键
+type importCheckpoints struct {
+	path string
+
+	mu     sync.Mutex
+	points map[string]*importCheckpoint
+}
+
+// ImportEvents 从 opts.Relays 拉取事件，校验 ID 和签名、去重后通过 SaveEvent 保存。
+// 各中继并行导入，每保存一页就更新一次断点，中断后再次调用会从断点继续。
+func (a *OrbitDBAdapter) ImportEvents(ctx context.Context, opts *ImportOptions) ([]ImportStats, error) {
+	if len(opts.Relays) == 0 {
+		return nil, fmt.Errorf("没有指定中继")
+	}
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultImportPageSize
+	}
+	filters := opts.Filters
+	if len(filters) == 0 {
+		filters = nostr.Filters{{}}
+	}
+
+	checkpoints, err := loadImportCheckpoints(opts.CheckpointFile)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]ImportStats, len(opts.Relays))
+	errs := make([]error, len(opts.Relays))
+	var wg sync.WaitGroup
+	for i, url := range opts.Relays {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			stats[i].Relay = url
+			if err := a.importRelay(ctx, url, filters, pageSize, opts.Accept, checkpoints, &stats[i]); err != nil {
+				stats[i].Error = err.Error()
+				errs[i] = fmt.Errorf("%s: %w", url, err)
+			}
+		}(i, url)
+	}
+	wg.Wait()
+
+	return stats, errors.Join(errs...)
+}
+
+// importRelay 依次导入一个中继上每个过滤器匹配的事件
+func (a *OrbitDBAdapter) importRelay(ctx context.Context, url string, filters nostr.Filters, pageSize int, accept func(*nostr.Event) error, checkpoints *importCheckpoints, stats *ImportStats) error {
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return fmt.Errorf("无法连接中继: %w", err)
+	}
+	defer relay.Close()
+
+	for _, filter := range filters {
+		key, err := checkpointKey(url, filter)
+		if err != nil {
+			return err
+		}
+		cp := checkpoints.get(key)
+
+		// 已完成过一轮时只回填更新的事件
+		since := filter.Since
+		if cp.Complete && (since == nil || *since < cp.Newest) {
+			newest := cp.Newest
+			since = &newest
+		}
+
+		until := cp.Until
+		if until == nil {
+			until = filter.Until
+		}
+		for {
+			page := filter
+			page.Since = since
+			page.Until = until
+			page.Limit = pageSize
+
+			events, err := queryPage(ctx, relay, url, page)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				return fmt.Errorf("查询失败: %w", err)
+			}
+			stats.Fetched += len(events)
+
+			var oldest nostr.Timestamp
+			for _, evt := range events {
+				if err := a.importEvent(ctx, evt, accept, stats); err != nil {
+					return err
+				}
+				if evt.CreatedAt > cp.Pending {
+					cp.Pending = evt.CreatedAt
+				}
+				if oldest == 0 || evt.CreatedAt < oldest {
+					oldest = evt.CreatedAt
+				}
+			}
+
+			if len(events) == 0 || (since != nil && oldest <= *since) {
+				// 这一轮已回填到底
+				if cp.Pending > cp.Newest {
+					cp.Newest = cp.Pending
+				}
+				cp.Until, cp.Pending, cp.Complete = nil, 0, true
+				if err := checkpoints.save(key, cp); err != nil {
+					return err
+				}
+				break
+			}
+
+			// until 包含边界，同一秒内的事件会再次返回并被去重；
+			// 整页都在同一秒时无法再按 until 分页，单独取完这一秒的事件后跳过它
+			next := oldest
+			if until != nil && next >= *until {
+				if len(events) >= pageSize {
+					if err := a.importSecond(ctx, relay, url, filter, *until, pageSize, accept, stats); err != nil {
+						return err
+					}
+				}
+				next = *until - 1
+			}
+			until = &next
+			cp.Until = until
+			if err := checkpoints.save(key, cp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// importSecond 导入 created_at 为 sec 的全部事件。这一秒的事件多于一页时，
+// 以 since=until=sec 请求并每次加倍 limit，直到中继返回的事件少于 limit；
+// 中继自己限制了 limit 时，超出它的部分仍然取不到
+func (a *OrbitDBAdapter) importSecond(ctx context.Context, relay *nostr.Relay, url string, filter nostr.Filter, sec nostr.Timestamp, limit int, accept func(*nostr.Event) error, stats *ImportStats) error {
+	for {
+		limit *= 2
+		page := filter
+		page.Since = &sec
+		page.Until = &sec
+		page.Limit = limit
+
+		events, err := queryPage(ctx, relay, url, page)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return fmt.Errorf("查询 %d 这一秒的事件失败: %w", sec, err)
+		}
+		stats.Fetched += len(events)
+		for _, evt := range events {
+			if err := a.importEvent(ctx, evt, accept, stats); err != nil {
+				return err
+			}
+		}
+		if len(events) < limit {
+			return nil
+		}
+	}
+}
+
+// queryPage 发送一个 REQ，返回 EOSE 之前收到的事件。超时、中继发送 CLOSED 或连接断开时
+// 这一页不完整，返回错误。返回前结束订阅并等到 CLOSE 写出，之后关闭连接不会与
+// go-nostr 在后台发送的 CLOSE 竞争（它的写协程会写入已置空的连接而崩溃）
+func queryPage(ctx context.Context, relay *nostr.Relay, url string, filter nostr.Filter) (events []*nostr.Event, err error) {
+	ctx, cancel := context.WithTimeout(ctx, importQueryTimeout)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "nostr.REQ", trace.WithAttributes(
+		append(filterAttributes(filter), attribute.String("nostr.relay", url))...))
+	defer func() {
+		span.SetAttributes(attribute.Int("nostr.events", len(events)))
+		endSpan(span, err)
+	}()
+
+	sub, err := relay.Subscribe(ctx, nostr.Filters{filter})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// 订阅结束后 Events 才会关闭，此时 CLOSE 已经写出
+		sub.Unsub()
+		for range sub.Events {
+		}
+	}()
+
+	for {
+		select {
+		case evt, ok := <-sub.Events:
+			if ok {
+				events = append(events, evt)
+				continue
+			}
+		case <-sub.EndOfStoredEvents:
+			return events, nil
+		case reason := <-sub.ClosedReason:
+			return events, fmt.Errorf("中继关闭了订阅: %s", reason)
+		case <-ctx.Done():
+		case <-relay.Context().Done():
+		}
+		switch {
+		case relay.Context().Err() != nil:
+			return events, fmt.Errorf("连接已断开: %w", context.Cause(relay.Context()))
+		case ctx.Err() != nil:
+			return events, fmt.Errorf("中继没有在 %s 内返回 EOSE: %w", importQueryTimeout, ctx.Err())
+		}
+		select {
+		case reason := <-sub.ClosedReason:
+			return events, fmt.Errorf("中继关闭了订阅: %s", reason)
+		default:
+			return events, errors.New("订阅在 EOSE 之前结束")
+		}
+	}
+}
+
+// importEvent 校验并保存一个事件
+func (a *OrbitDBAdapter) importEvent(ctx context.Context, evt *nostr.Event, accept func(*nostr.Event) error, stats *ImportStats) error {
+	if !validEvent(evt) {
+		stats.Invalid++
+		a.metrics.Reject(RejectInvalid)
+		return nil
+	}
+	if accept != nil {
+		if err := accept(evt); err != nil {
+			stats.Rejected++
+			a.metrics.Reject(RejectPolicy)
+			return nil
+		}
+	}
+
+	exists, err := a.hasEvent(ctx, evt.ID)
+	if err != nil {
+		return err
+	}
+	// 与 reconcile 一样，本地删除过的事件不再导入
+	if !exists && a.index.isDeleted(evt.ID) {
+		stats.Deleted++
+		return nil
+	}
+	if exists {
+		stats.Duplicates++
+		a.metrics.Reject(RejectDuplicate)
+		return nil
+	}
+	if err := a.SaveEvent(ctx, evt); err != nil {
+		return fmt.Errorf("保存事件 %s 失败: %w", evt.ID, err)
+	}
+	stats.Saved++
+	return nil
+}
+
+// hasEvent 报告数据库中是否已有该 ID 的事件
+func (a *OrbitDBAdapter) hasEvent(ctx context.Context, id string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return a.index.get(id) != nil, nil
+}
+
+// checkpointKey 由中继 URL 和过滤器构成的断点键
+func checkpointKey(url string, filter nostr.Filter) (string, error) {
+	data, err := json.Marshal(filter)
+	if err != nil {
+		return "", fmt.Errorf("无法编码过滤器: %w", err)
+	}
+	return nostr.NormalizeURL(url) + " " + string(data), nil
+}
+
+// loadImportCheckpoints 读取断点文件，文件不存在时返回空的断点
+func loadImportCheckpoints(path string) (*importCheckpoints, error) {
+	c := &importCheckpoints{path: path, points: make(map[string]*importCheckpoint)}
+	if path == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("无法读取断点文件: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.points); err != nil {
+		return nil, fmt.Errorf("断点文件 %s 格式错误: %w", path, err)
+	}
+	return c, nil
+}
+
+// get 返回 key 的断点副本
+func (c *importCheckpoints) get(key string) importCheckpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cp, ok := c.points[key]; ok {
+		return *cp
+	}
+	return importCheckpoint{}
+}
+
+// save 更新 key 的断点并写入文件
+func (c *importCheckpoints) save(key string, cp importCheckpoint) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.points[key] = &cp
+	if c.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c.points, "", "  ")
+	if err != nil {
+		return fmt.Errorf("无法编码断点: %w", err)
+	}
+	// 先写临时文件再改名，中断时不会留下半个文件
+	tmp := c.path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("无法创建断点目录: %w", err)
+	}
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("无法写入断点文件: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("无法写入断点文件: %w", err)
+	}
+	return nil
+}
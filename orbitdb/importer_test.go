@@ -0,0 +1,369 @@
+package orbitdb_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/coder/websocket"
+	"github.com/nbd-wtf/go-nostr"
+
+	nostrstore "github.com/maoaixiao1314/orbitdb/orbitdb"
+)
+
+// testRelay 回应 REQ 的中继替身：按 created_at 从新到旧、同一秒内按 ID 排序发送
+// 匹配的事件，最多 limit 个，然后发送 EOSE。它记录收到的每个过滤器，
+// 并以 OK 回应发布的事件
+type testRelay struct {
+	mu     sync.Mutex
+	events []*nostr.Event
+	reqs   []nostr.Filter
+	// drop 为 n 时在第 n 个 REQ 上断开连接而不回应
+	drop int
+	// published 收到的 EVENT，重复发布的事件出现多次
+	published []*nostr.Event
+	// dropEvent 为 n 时在第 n 个 EVENT 上断开连接而不回应
+	dropEvent int
+	// reject 按事件 ID 给出以 OK false 回应时的原因
+	reject map[string]string
+}
+
+// newTestRelay 启动一个保存 events 的中继替身，返回它和它的 URL
+func newTestRelay(t *testing.T, events ...*nostr.Event) (*testRelay, string) {
+	t.Helper()
+	r := &testRelay{events: events}
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return r, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func (r *testRelay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	conn, err := websocket.Accept(w, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+
+	ctx := req.Context()
+	for {
+		_, msg, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		var replies [][]byte
+		var drop bool
+		switch env := nostr.ParseMessage(msg).(type) {
+		case *nostr.ReqEnvelope:
+			replies, drop = r.answer(env)
+		case *nostr.EventEnvelope:
+			replies, drop = r.accept(&env.Event)
+		default:
+			continue
+		}
+		if drop {
+			return
+		}
+		for _, reply := range replies {
+			if err := conn.Write(ctx, websocket.MessageText, reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// answer 返回对 env 的回应，需要断开连接时返回 true
+func (r *testRelay) answer(env *nostr.ReqEnvelope) ([][]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	filter := env.Filters[0]
+	r.reqs = append(r.reqs, filter)
+	if len(r.reqs) == r.drop {
+		return nil, true
+	}
+
+	var matched []*nostr.Event
+	for _, evt := range r.events {
+		if filter.Matches(evt) {
+			matched = append(matched, evt)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].CreatedAt != matched[j].CreatedAt {
+			return matched[i].CreatedAt > matched[j].CreatedAt
+		}
+		return matched[i].ID < matched[j].ID
+	})
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[:filter.Limit]
+	}
+
+	var replies [][]byte
+	for _, evt := range matched {
+		data, _ := nostr.EventEnvelope{SubscriptionID: &env.SubscriptionID, Event: *evt}.MarshalJSON()
+		replies = append(replies, data)
+	}
+	eose, _ := nostr.EOSEEnvelope(env.SubscriptionID).MarshalJSON()
+	return append(replies, eose), false
+}
+
+// accept 返回对发布 evt 的 OK 回应，需要断开连接时返回 true
+func (r *testRelay) accept(evt *nostr.Event) ([][]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.published = append(r.published, evt)
+	if len(r.published) == r.dropEvent {
+		return nil, true
+	}
+	reason, rejected := r.reject[evt.ID]
+	ok, _ := nostr.OKEnvelope{EventID: evt.ID, OK: !rejected, Reason: reason}.MarshalJSON()
+	return [][]byte{ok}, false
+}
+
+// publishCount 返回每个事件被发布的次数
+func (r *testRelay) publishCount() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts := make(map[string]int)
+	for _, evt := range r.published {
+		counts[evt.ID]++
+	}
+	return counts
+}
+
+// add 向中继添加事件
+func (r *testRelay) add(events ...*nostr.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, events...)
+}
+
+// setDrop 设置断开连接的 REQ 序号，0 表示不断开
+func (r *testRelay) setDrop(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.drop = n
+}
+
+// requests 返回收到的过滤器
+func (r *testRelay) requests() []nostr.Filter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]nostr.Filter(nil), r.reqs...)
+}
+
+// untils 返回 filters 的 until，没有 until 时为 0
+func untils(filters []nostr.Filter) []nostr.Timestamp {
+	var ts []nostr.Timestamp
+	for _, f := range filters {
+		var until nostr.Timestamp
+		if f.Until != nil {
+			until = *f.Until
+		}
+		ts = append(ts, until)
+	}
+	return ts
+}
+
+// signedEvents 返回在 times 创建的已签名事件
+func signedEvents(t *testing.T, times ...nostr.Timestamp) []*nostr.Event {
+	t.Helper()
+	sk := nostr.GeneratePrivateKey()
+	events := make([]*nostr.Event, len(times))
+	for i, ts := range times {
+		evt := &nostr.Event{CreatedAt: ts, Kind: 1, Tags: nostr.Tags{}, Content: fmt.Sprint("event ", i)}
+		if err := evt.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		events[i] = evt
+	}
+	return events
+}
+
+// storedIDs 返回适配器中全部事件的 ID
+func storedIDs(t *testing.T, adapter *nostrstore.OrbitDBAdapter) map[string]bool {
+	t.Helper()
+	ch, err := adapter.QueryEvents(context.Background(), nostr.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]bool)
+	for evt := range ch {
+		ids[evt.ID] = true
+	}
+	return ids
+}
+
+// importOnce 从 url 导入一次，返回该中继的统计
+func importOnce(t *testing.T, adapter *nostrstore.OrbitDBAdapter, url, checkpoint string, pageSize int) (nostrstore.ImportStats, error) {
+	t.Helper()
+	stats, err := adapter.ImportEvents(context.Background(), &nostrstore.ImportOptions{
+		Relays:         []string{url},
+		PageSize:       pageSize,
+		CheckpointFile: checkpoint,
+	})
+	if len(stats) != 1 {
+		t.Fatalf("统计有 %d 项，应为 1 项", len(stats))
+	}
+	return stats[0], err
+}
+
+func TestImportUntilBoundary(t *testing.T) {
+	adapter := testAdapter(t)
+	events := signedEvents(t, 100, 90, 90, 80, 70)
+	relay, url := newTestRelay(t, events...)
+
+	stats, err := importOnce(t, adapter, url, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Saved != 5 {
+		t.Errorf("保存了 %d 个事件，应为 5 个", stats.Saved)
+	}
+	// until 包含边界：边界上的事件重复返回并被去重；整页都在 90 这一秒时单独取完这一秒再跳到 89
+	want := []nostr.Timestamp{0, 90, 90, 89, 70, 69}
+	if got := untils(relay.requests()); !reflect.DeepEqual(got, want) {
+		t.Errorf("各页的 until 为 %v，应为 %v", got, want)
+	}
+	if stats.Duplicates != 4 {
+		t.Errorf("重复 %d 个事件，应为 4 个", stats.Duplicates)
+	}
+	if got := storedIDs(t, adapter); len(got) != 5 {
+		t.Errorf("适配器中有 %d 个事件，应为 5 个", len(got))
+	}
+}
+
+func TestImportSameSecondOverflow(t *testing.T) {
+	adapter := testAdapter(t)
+	events := signedEvents(t, 100, 100, 100, 50)
+	relay, url := newTestRelay(t, events...)
+
+	stats, err := importOnce(t, adapter, url, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 同一秒的事件多于一页时，以 since=until 和加倍的 limit 取完这一秒再跳过它
+	reqs := relay.requests()
+	want := []nostr.Timestamp{0, 100, 100, 99, 50, 49}
+	if got := untils(reqs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("各页的 until 为 %v，应为 %v", got, want)
+	}
+	if reqs[2].Since == nil || *reqs[2].Since != 100 || reqs[2].Limit != 4 {
+		t.Errorf("取 100 这一秒的请求为 %s，应为 since=until=100、limit=4", reqs[2])
+	}
+
+	stored := storedIDs(t, adapter)
+	if stats.Saved != len(events) || len(stored) != len(events) {
+		t.Errorf("保存了 %d 个事件，适配器中有 %d 个，应都为 %d 个", stats.Saved, len(stored), len(events))
+	}
+	for _, evt := range events {
+		if !stored[evt.ID] {
+			t.Errorf("事件 %s（created_at %d）没有导入", evt.ID, evt.CreatedAt)
+		}
+	}
+}
+
+func TestImportSkipsDeleted(t *testing.T) {
+	adapter := testAdapter(t)
+	events := signedEvents(t, 100, 90)
+	_, url := newTestRelay(t, events...)
+
+	if _, err := importOnce(t, adapter, url, "", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.DeleteEvent(context.Background(), events[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// 本地删除过的事件再次导入时跳过，不会被恢复；另一个事件在 until 边界上返回两次
+	stats, err := importOnce(t, adapter, url, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Deleted != 1 || stats.Duplicates != 2 || stats.Saved != 0 {
+		t.Errorf("跳过已删除 %d 个、重复 %d 个、保存 %d 个，应为 1、2、0 个", stats.Deleted, stats.Duplicates, stats.Saved)
+	}
+	if storedIDs(t, adapter)[events[0].ID] {
+		t.Errorf("已删除的事件 %s 被重新导入", events[0].ID)
+	}
+}
+
+func TestImportSinceCheckpoint(t *testing.T) {
+	adapter := testAdapter(t)
+	checkpoint := filepath.Join(t.TempDir(), "import.json")
+	first := signedEvents(t, 100, 90)
+	relay, url := newTestRelay(t, first...)
+
+	if _, err := importOnce(t, adapter, url, checkpoint, 10); err != nil {
+		t.Fatal(err)
+	}
+	done := len(relay.requests())
+
+	// 完成一轮后只回填比上一轮最新事件更新的部分，之后才到达的旧事件不再拉取
+	later := signedEvents(t, 120, 110, 80)
+	relay.add(later...)
+	stats, err := importOnce(t, adapter, url, checkpoint, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := relay.requests()[done:]
+	if len(reqs) != 1 {
+		t.Fatalf("第二轮发送了 %d 个 REQ，应为 1 个", len(reqs))
+	}
+	if reqs[0].Since == nil || *reqs[0].Since != 100 {
+		t.Errorf("第二轮的请求为 %s，since 应为 100", reqs[0])
+	}
+	if stats.Saved != 2 || stats.Duplicates != 1 {
+		t.Errorf("第二轮保存 %d 个、重复 %d 个，应为 2 个和 1 个", stats.Saved, stats.Duplicates)
+	}
+	if storedIDs(t, adapter)[later[2].ID] {
+		t.Errorf("早于 since 的事件 %s 不应被导入", later[2].ID)
+	}
+}
+
+func TestImportResume(t *testing.T) {
+	adapter := testAdapter(t)
+	checkpoint := filepath.Join(t.TempDir(), "import.json")
+	events := signedEvents(t, 100, 90, 80, 70)
+	relay, url := newTestRelay(t, events...)
+
+	// 第二页的请求上断开连接
+	relay.setDrop(2)
+	stats, err := importOnce(t, adapter, url, checkpoint, 2)
+	if err == nil {
+		t.Fatal("连接断开时导入没有返回错误")
+	}
+	if stats.Saved != 2 {
+		t.Errorf("中断前保存了 %d 个事件，应为 2 个", stats.Saved)
+	}
+	done := len(relay.requests())
+
+	// 再次导入从断点的 until 继续，而不是把中断的一轮当作已完成
+	relay.setDrop(0)
+	stats, err = importOnce(t, adapter, url, checkpoint, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := relay.requests()[done:]
+	if len(reqs) == 0 {
+		t.Fatal("继续导入没有发送 REQ")
+	}
+	if reqs[0].Until == nil || *reqs[0].Until != 90 {
+		t.Errorf("继续导入的各页 until 为 %v，第一页应为 90", untils(reqs))
+	}
+	if reqs[0].Since != nil {
+		t.Errorf("继续导入的第一页不应有 since，实际为 %v", *reqs[0].Since)
+	}
+	if stats.Saved != 2 {
+		t.Errorf("继续导入保存了 %d 个事件，应为 2 个", stats.Saved)
+	}
+	if got := storedIDs(t, adapter); len(got) != 4 {
+		t.Errorf("适配器中有 %d 个事件，应为 4 个", len(got))
+	}
+}
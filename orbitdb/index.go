@@ -0,0 +1,162 @@
+package orbitdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-orbit-db/stores/operation"
+)
+
+// indexedDoc 一个键上时钟最新的操作
+type indexedDoc struct {
+	// Entry 操作所在条目的 CID
+	Entry string `json:"entry"`
+	// Time 和 Clock 是条目的 Lamport 时钟，用于决定同一个键上哪个操作胜出
+	Time  int    `json:"time"`
+	Clock []byte `json:"clock"`
+	// Value 文档的 JSON，删除时为空
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// newer 报告 d 是否比 other 更新，比较顺序与日志的 LastWriteWins 相同
+func (d *indexedDoc) newer(other *indexedDoc) bool {
+	if d.Time != other.Time {
+		return d.Time > other.Time
+	}
+	if c := bytes.Compare(d.Clock, other.Clock); c != 0 {
+		return c > 0
+	}
+	return d.Entry > other.Entry
+}
+
+// docIndex 适配器自己的文档索引。与 documentstore 的索引一样每个键只保留最新的操作，
+// 但按条目增量更新，不必每次重放整个日志，并且可以保存为快照。
+type docIndex struct {
+	mu   sync.RWMutex
+	docs map[string]*indexedDoc
+}
+
+func newDocIndex() *docIndex {
+	return &docIndex{docs: make(map[string]*indexedDoc)}
+}
+
+// apply 将条目中的操作合并到索引，重复合并同一条目没有影响
+func (x *docIndex) apply(entries ...ipfslog.Entry) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, e := range entries {
+		if err := x.applyLocked(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *docIndex) applyLocked(e ipfslog.Entry) error {
+	op, err := operation.ParseOperation(e)
+	if err != nil {
+		return fmt.Errorf("无法解析条目 %s: %w", e.GetHash(), err)
+	}
+	clock := e.GetClock()
+	doc := indexedDoc{Entry: e.GetHash().String(), Time: clock.GetTime(), Clock: clock.GetID()}
+
+	set := func(key string, value []byte) {
+		if key == "" {
+			return
+		}
+		d := doc
+		d.Value = value
+		if cur, ok := x.docs[key]; ok && !d.newer(cur) {
+			return
+		}
+		x.docs[key] = &d
+	}
+
+	switch op.GetOperation() {
+	case "PUT":
+		if key := op.GetKey(); key != nil {
+			set(*key, op.GetValue())
+		}
+	case "PUTALL":
+		for _, d := range op.GetDocs() {
+			set(d.GetKey(), d.GetValue())
+		}
+	case "DEL":
+		if key := op.GetKey(); key != nil {
+			set(*key, nil)
+		}
+	}
+	return nil
+}
+
+// merge 将另一个索引的文档按时钟合并进来
+func (x *docIndex) merge(docs map[string]*indexedDoc) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for key, d := range docs {
+		if cur, ok := x.docs[key]; ok && !d.newer(cur) {
+			continue
+		}
+		x.docs[key] = d
+	}
+}
+
+// get 返回 key 对应文档的 JSON，不存在或已删除时返回 nil
+func (x *docIndex) get(key string) []byte {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if d, ok := x.docs[key]; ok {
+		return d.Value
+	}
+	return nil
+}
+
+// values 返回所有未删除文档的 JSON
+func (x *docIndex) values() [][]byte {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	values := make([][]byte, 0, len(x.docs))
+	for _, d := range x.docs {
+		if d.Value != nil {
+			values = append(values, d.Value)
+		}
+	}
+	return values
+}
+
+// count 返回未删除文档的数量
+func (x *docIndex) count() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	n := 0
+	for _, d := range x.docs {
+		if d.Value != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// deleted 返回已删除的键
+func (x *docIndex) deleted() map[string]bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	deleted := make(map[string]bool)
+	for key, d := range x.docs {
+		if d.Value == nil {
+			deleted[key] = true
+		}
+	}
+	return deleted
+}
+
+// isDeleted 报告 key 是否已被删除
+func (x *docIndex) isDeleted(key string) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	d, ok := x.docs[key]
+	return ok && d.Value == nil
+}
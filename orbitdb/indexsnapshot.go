@@ -0,0 +1,256 @@
+package orbitdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-orbit-db/iface"
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+)
+
+// indexSnapshot 文档索引快照文件的内容
+type indexSnapshot struct {
+	Address string    `json:"address"`
+	Heads   []cid.Cid `json:"heads"`
+	// Entries 快照覆盖的全部条目，即 Heads 及其全部祖先
+	Entries []cid.Cid              `json:"entries"`
+	Docs    map[string]*indexedDoc `json:"docs"`
+}
+
+// SaveIndexSnapshot 将文档索引连同它覆盖的日志 heads 和条目写入 path。
+// 写入前会合并已加载的整个日志；没有加载的祖先已被启动时加载的快照覆盖，
+// 快照因此包含这些 heads 及其全部祖先中的操作。
+func (a *OrbitDBAdapter) SaveIndexSnapshot(path string) error {
+	snap := indexSnapshot{Address: a.db.Address().String()}
+
+	// 持有索引的锁读取日志：此前合并过的条目都已在日志中，
+	// 先取 heads 再取日志，日志中包含 heads 的全部已加载祖先
+	a.index.mu.Lock()
+	heads := a.db.OpLog().Heads().Slice()
+	entries := a.db.OpLog().Values().Slice()
+	for _, e := range entries {
+		if err := a.index.applyLocked(e); err != nil {
+			a.index.mu.Unlock()
+			return err
+		}
+	}
+	a.coveredMu.RLock()
+	snap.Entries = make([]cid.Cid, 0, len(a.covered)+len(entries))
+	for c := range a.covered {
+		snap.Entries = append(snap.Entries, c)
+	}
+	for _, e := range entries {
+		if _, ok := a.covered[e.GetHash()]; !ok {
+			snap.Entries = append(snap.Entries, e.GetHash())
+		}
+	}
+	a.coveredMu.RUnlock()
+	// 索引中的文档合并后不再修改，复制映射即可
+	snap.Docs = make(map[string]*indexedDoc, len(a.index.docs))
+	for key, d := range a.index.docs {
+		snap.Docs[key] = d
+	}
+	a.index.mu.Unlock()
+
+	for _, head := range heads {
+		snap.Heads = append(snap.Heads, head.GetHash())
+	}
+
+	data, err := json.Marshal(&snap)
+	if err != nil {
+		return fmt.Errorf("无法编码索引快照: %w", err)
+	}
+	// 先写临时文件再改名，中断时不会留下半个文件
+	tmp := path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("无法创建快照目录: %w", err)
+	}
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("无法写入索引快照: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("无法写入索引快照: %w", err)
+	}
+	return nil
+}
+
+// LoadIndexSnapshot 读取 SaveIndexSnapshot 写入的快照，再从存储缓存的 heads 沿日志回溯，
+// 只合并快照之后的条目。应在 db.Load 之前调用。快照不存在时返回的错误满足 errors.Is(err, os.ErrNotExist)。
+//
+// 返回调用方应传给 db.Load 的条目数：从缓存的 heads 可达、时钟不早于最早的新条目和 heads 的条目数。
+// Load 从每个 head 按时钟从新到旧取条目，取这么多条目恰好包含全部新条目和 heads，
+// 快照覆盖的更早的历史不会加载；之后 HasEntry 仍把它们当作本地已有的条目。
+func (a *OrbitDBAdapter) LoadIndexSnapshot(ctx context.Context, path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("无法读取索引快照: %w", err)
+	}
+	snap := indexSnapshot{}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return 0, fmt.Errorf("索引快照 %s 格式错误: %w", path, err)
+	}
+	if snap.Address != a.db.Address().String() {
+		return 0, fmt.Errorf("索引快照属于另一个数据库 %s", snap.Address)
+	}
+	if snap.Entries == nil && len(snap.Heads) > 0 {
+		return 0, fmt.Errorf("索引快照 %s 没有记录覆盖的条目", path)
+	}
+	if snap.Docs == nil {
+		snap.Docs = make(map[string]*indexedDoc)
+	}
+	loaded := &docIndex{docs: snap.Docs}
+
+	covered := make(map[cid.Cid]struct{}, len(snap.Entries))
+	for _, c := range snap.Entries {
+		covered[c] = struct{}{}
+	}
+
+	heads, err := cachedHeads(ctx, a.db.Cache())
+	if err != nil {
+		return 0, err
+	}
+
+	// 回溯到快照覆盖的条目为止，合并其间的新条目
+	read := make(map[cid.Cid]ipfslog.Entry)
+	minTime := -1
+	pending := append([]cid.Cid(nil), heads...)
+	for len(pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		c := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if _, ok := covered[c]; ok {
+			continue
+		}
+		if _, ok := read[c]; ok {
+			continue
+		}
+		e, err := readEntry(ctx, a.db, c)
+		if err != nil {
+			return 0, err
+		}
+		read[c] = e
+		if err := loaded.apply(e); err != nil {
+			return 0, err
+		}
+		if t := e.GetClock().GetTime(); minTime < 0 || t < minTime {
+			minTime = t
+		}
+		pending = append(pending, e.GetNext()...)
+	}
+	for _, c := range heads {
+		e, ok := read[c]
+		if !ok {
+			if e, err = readEntry(ctx, a.db, c); err != nil {
+				return 0, err
+			}
+			read[c] = e
+		}
+		if t := e.GetClock().GetTime(); minTime < 0 || t < minTime {
+			minTime = t
+		}
+	}
+
+	// 条目的时钟总是大于它的 next，时钟早于 minTime 的条目及其祖先都不必加载
+	amount := 0
+	seen := make(map[cid.Cid]bool)
+	pending = append(pending, heads...)
+	for len(pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		c := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		e, ok := read[c]
+		if !ok {
+			if e, err = readEntry(ctx, a.db, c); err != nil {
+				return 0, err
+			}
+		}
+		if e.GetClock().GetTime() < minTime {
+			continue
+		}
+		amount++
+		pending = append(pending, e.GetNext()...)
+	}
+
+	a.index.merge(loaded.docs)
+	for c := range read {
+		covered[c] = struct{}{}
+	}
+	a.coveredMu.Lock()
+	a.covered = covered
+	a.coveredMu.Unlock()
+	return amount, nil
+}
+
+// HasEntry 报告条目 c 是否已在本地：在已加载的日志中，或被启动时加载的索引快照覆盖。
+// 从快照启动时 Load 不加载快照之前的条目，只检查 db.OpLog() 会把它们当作缺失
+func (a *OrbitDBAdapter) HasEntry(c cid.Cid) bool {
+	if _, ok := a.db.OpLog().Get(c); ok {
+		return true
+	}
+	a.coveredMu.RLock()
+	defer a.coveredMu.RUnlock()
+	_, ok := a.covered[c]
+	return ok
+}
+
+// entryCheck 返回 has，has 为 nil 时返回只检查 db 已加载的日志的函数
+func entryCheck(db iface.Store, has func(cid.Cid) bool) func(cid.Cid) bool {
+	if has != nil {
+		return has
+	}
+	return func(c cid.Cid) bool {
+		_, ok := db.OpLog().Get(c)
+		return ok
+	}
+}
+
+// readEntry 读取并解析条目 c
+func readEntry(ctx context.Context, db iface.Store, c cid.Cid) (ipfslog.Entry, error) {
+	node, err := db.IO().Read(ctx, db.IPFS(), c)
+	if err != nil {
+		return nil, fmt.Errorf("读取条目 %s 失败: %w", c, err)
+	}
+	e, err := db.IO().DecodeRawEntry(node, c, db.Identity().Provider)
+	if err != nil {
+		return nil, fmt.Errorf("解析条目 %s 失败: %w", c, err)
+	}
+	return e, nil
+}
+
+// cachedHeads 返回存储缓存中记录的本地和远端 heads，即 Load 开始回溯的位置
+func cachedHeads(ctx context.Context, cache datastore.Datastore) ([]cid.Cid, error) {
+	var hashes []cid.Cid
+	for _, key := range []string{"_localHeads", "_remoteHeads"} {
+		data, err := cache.Get(ctx, datastore.NewKey(key))
+		if errors.Is(err, datastore.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("无法读取缓存的 heads: %w", err)
+		}
+		var heads []*entry.Entry
+		if err := json.Unmarshal(data, &heads); err != nil {
+			return nil, fmt.Errorf("缓存的 heads 格式错误: %w", err)
+		}
+		for _, head := range heads {
+			if head != nil {
+				hashes = append(hashes, head.GetHash())
+			}
+		}
+	}
+	return hashes, nil
+}
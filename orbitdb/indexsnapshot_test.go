@@ -0,0 +1,218 @@
+package orbitdb_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	orbitdb "berty.tech/go-orbit-db"
+	"berty.tech/go-orbit-db/accesscontroller"
+	"berty.tech/go-orbit-db/iface"
+	cid "github.com/ipfs/go-cid"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/nbd-wtf/go-nostr"
+
+	nostrstore "github.com/maoaixiao1314/orbitdb/orbitdb"
+)
+
+// openDocs 打开或创建 orbit 中的文档数据库（所有人可写），返回它的适配器，由调用方关闭
+func openDocs(ctx context.Context, t *testing.T, orbit iface.OrbitDB, address string) adapterStore {
+	t.Helper()
+	return openDocsWith(ctx, t, orbit, address, true)
+}
+
+// openDocsWith 同 openDocs，replicate 为 false 时数据库不与对端复制
+func openDocsWith(ctx context.Context, t *testing.T, orbit iface.OrbitDB, address string, replicate bool) adapterStore {
+	t.Helper()
+	create := true
+	db, err := orbit.Docs(ctx, address, &orbitdb.CreateDBOptions{
+		AccessController: &accesscontroller.CreateAccessControllerOptions{
+			Access: map[string][]string{"write": {"*"}},
+		},
+		Create:    &create,
+		Replicate: &replicate,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter, err := nostrstore.NewOrbitDBAdapter(db)
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	return adapterStore{OrbitDBAdapter: adapter, db: db}
+}
+
+// saveEvents 依次保存 events
+func saveEvents(t *testing.T, store adapterStore, events ...*nostr.Event) {
+	t.Helper()
+	for _, evt := range events {
+		if err := store.SaveEvent(context.Background(), evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// entryHashes 返回 db 已加载的日志中全部条目的 CID
+func entryHashes(db iface.DocumentStore) []cid.Cid {
+	var hashes []cid.Cid
+	for _, e := range db.OpLog().Values().Slice() {
+		hashes = append(hashes, e.GetHash())
+	}
+	return hashes
+}
+
+// loadSnapshot 像节点启动时一样加载索引快照，再加载快照之后的条目，返回加载的条目数
+func loadSnapshot(ctx context.Context, t *testing.T, store adapterStore, path string) int {
+	t.Helper()
+	amount, err := store.LoadIndexSnapshot(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.db.Load(ctx, max(amount, 1)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "索引合并加载的日志", store.IndexReady)
+	return amount
+}
+
+// waitFor 等待 cond 成立，超时时测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(20 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestIndexSnapshotRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	orbit := testOrbitDB(ctx, t)
+	path := filepath.Join(t.TempDir(), "index-snapshot.json")
+	events := signedEvents(t, 1, 2, 3, 4, 5, 6, 7, 8)
+
+	store := openDocs(ctx, t, orbit, "snapshot")
+	saveEvents(t, store, events[:5]...)
+	if err := store.SaveIndexSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	covered := entryHashes(store.db)
+	saveEvents(t, store, events[5:]...)
+	store.Close()
+
+	// 重启后只加载快照之后的 3 个条目，快照之前的条目仍算作本地已有
+	store = openDocs(ctx, t, orbit, "snapshot")
+	if amount := loadSnapshot(ctx, t, store, path); amount != 3 {
+		t.Errorf("应加载 %d 个条目，应为 3 个", amount)
+	}
+	if n := store.db.OpLog().Len(); n != 3 {
+		t.Errorf("日志中加载了 %d 个条目，应为 3 个", n)
+	}
+	if got := storedIDs(t, store.OrbitDBAdapter); len(got) != len(events) {
+		t.Errorf("适配器中有 %d 个事件，应为 %d 个", len(got), len(events))
+	}
+	for _, c := range covered {
+		if !store.HasEntry(c) {
+			t.Errorf("快照覆盖的条目 %s 被当作缺失", c)
+		}
+	}
+
+	// 部分加载后保存的快照仍覆盖全部条目，再次重启时只需加载 head
+	if err := store.SaveIndexSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	all := append(covered, entryHashes(store.db)...)
+	store.Close()
+
+	store = openDocs(ctx, t, orbit, "snapshot")
+	defer store.Close()
+	if amount := loadSnapshot(ctx, t, store, path); amount != 1 {
+		t.Errorf("再次重启应加载 %d 个条目，应为 1 个", amount)
+	}
+	if got := storedIDs(t, store.OrbitDBAdapter); len(got) != len(events) {
+		t.Errorf("再次重启后适配器中有 %d 个事件，应为 %d 个", len(got), len(events))
+	}
+	for _, c := range all {
+		if !store.HasEntry(c) {
+			t.Errorf("再次重启后条目 %s 被当作缺失", c)
+		}
+	}
+}
+
+func TestIndexSnapshotReplicate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+	orbitA, nodeA := testNode(ctx, t, mn)
+	orbitB, nodeB := testNode(ctx, t, mn)
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "index-snapshot.json")
+	events := signedEvents(t, 1, 2, 3, 4, 5)
+
+	a := openDocs(ctx, t, orbitA, "replicate")
+	saveEvents(t, a, events[:4]...)
+	if err := a.SaveIndexSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	covered := entryHashes(a.db)
+	a.Close()
+
+	// A 从快照重启，只加载 head
+	a = openDocs(ctx, t, orbitA, "replicate")
+	defer a.Close()
+	address := a.db.Address().String()
+	tracker, err := nostrstore.NewReplicationTracker(ctx, orbitA, a.db, a.HasEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Close()
+	loadSnapshot(ctx, t, a, path)
+
+	exA, err := nostrstore.NewHeadsExchange(ctx, nodeA.PeerHost, orbitA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exA.Close()
+	exA.Register(a.db, a.HasEntry)
+	exB, err := nostrstore.NewHeadsExchange(ctx, nodeB.PeerHost, orbitB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exB.Close()
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 快照覆盖而没有加载的条目从块存储读取后应答
+	resp, err := exB.Request(ctx, nodeA.Identity, address, covered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Entries) != len(covered) {
+		t.Errorf("应答了 %d 个条目，应为 %d 个", len(resp.Entries), len(covered))
+	}
+
+	// B 从 A 复制快照之前写入的全部事件
+	b := openDocs(ctx, t, orbitB, address)
+	defer b.Close()
+	waitFor(t, "B 复制 A 的事件", func() bool { return len(storedIDs(t, b.OrbitDBAdapter)) == 4 })
+
+	// B 写入的事件只需复制这一个条目，A 与 B 同步而不必加载快照之前的历史
+	saveEvents(t, b, events[4])
+	waitFor(t, "A 复制 B 的事件", func() bool { return len(storedIDs(t, a.OrbitDBAdapter)) == 5 })
+	waitFor(t, "A 与 B 同步", func() bool {
+		status := tracker.Status()
+		return status.Synced && len(status.Peers) > 0
+	})
+	if n := a.db.OpLog().Len(); n >= len(events) {
+		t.Errorf("A 的日志中加载了 %d 个条目，不应加载快照之前的历史", n)
+	}
+}
@@ -3,7 +3,6 @@ package orbitdb
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"sync"
@@ -100,9 +99,7 @@ func Init() error {
 		documentDB = db
 
 		initialized = true
-		addr := documentDB.Address()
-		log.Printf("文档数据库地址: %s", addr)
-		log.Println("数据库初始化成功")
+		componentLogger("init").Info("database initialized", "address", documentDB.Address().String())
 	})
 
 	return initErr
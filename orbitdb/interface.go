@@ -0,0 +1,41 @@
+package orbitdb
+
+import (
+	"context"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Store 事件存储的方法集。嵌入的 OrbitDBAdapter、内存中的 MemoryStore 和通过 gRPC
+// 访问远程节点的 client.Client 都实现了它（client 包中断言），应用代码可以按配置选择其中之一。
+// 每个实现都应当通过 storetest.Run 的一致性检查
+type Store interface {
+	// SaveEvent 保存事件，保存已有的事件不算错误。存储关闭后返回 ErrClosed
+	SaveEvent(ctx context.Context, event *nostr.Event) error
+	// QueryEvents 按 created_at 从新到旧返回匹配过滤器的事件，filter.Limit 大于 0 时最多返回这么多个。
+	// 查询失败时返回错误，而不是关闭空的通道；发送完或 ctx 结束时关闭通道
+	QueryEvents(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error)
+	// CountEvents 返回匹配过滤器的事件数，不受 filter.Limit 限制
+	CountEvents(ctx context.Context, filter nostr.Filter) (int64, error)
+	// DeleteEvent 删除 ID 为 event.ID 的事件，事件不存在时返回 ErrNotFound，存储关闭后返回 ErrClosed
+	DeleteEvent(ctx context.Context, event *nostr.Event) error
+	// Subscribe 返回调用之后保存的、匹配任一过滤器的事件，没有过滤器时匹配全部事件。
+	// ctx 结束或存储关闭时关闭通道
+	Subscribe(ctx context.Context, filters nostr.Filters) (chan *nostr.Event, error)
+	// Close 停止接受新的写入并释放资源
+	Close()
+}
+
+// FiltersQuerier 可以一次扫描求值 NIP-01 REQ 中多个过滤器的存储
+type FiltersQuerier interface {
+	// QueryFilters 返回各过滤器最多 limit 个最新事件的并集，去重后按 created_at 从新到旧排序。
+	// 没有过滤器时不返回事件。查询失败时返回错误；发送完或 ctx 结束时关闭通道
+	QueryFilters(ctx context.Context, filters nostr.Filters) (chan *nostr.Event, error)
+}
+
+var (
+	_ Store          = (*OrbitDBAdapter)(nil)
+	_ Store          = (*MemoryStore)(nil)
+	_ FiltersQuerier = (*OrbitDBAdapter)(nil)
+	_ FiltersQuerier = (*MemoryStore)(nil)
+)
@@ -0,0 +1,117 @@
+package orbitdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// jsonlMaxLine JSONL 中单行的最大字节数
+	jsonlMaxLine = 4 << 20
+	// jsonlProgressInterval 每读取多少行报告一次进度
+	jsonlProgressInterval = 1000
+)
+
+// ExportEvents 将数据库中匹配 filters 的事件逐行写成 nostr 事件 JSON（JSONL），
+// filters 为空时导出全部事件。文档在查询过程中直接写出，不会整体载入内存。
+// 返回写出的事件数。
+func (a *OrbitDBAdapter) ExportEvents(ctx context.Context, w io.Writer, filters nostr.Filters) (int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+
+	count := 0
+	var writeErr error
+	_, err := a.query(ctx, func(doc interface{}) (bool, error) {
+		if writeErr != nil {
+			return false, nil
+		}
+		docMap, ok := doc.(map[string]interface{})
+		if !ok {
+			return false, nil
+		}
+		evt := docToEvent(docMap)
+		if len(filters) > 0 && !filters.Match(evt) {
+			return false, nil
+		}
+		if err := ctx.Err(); err != nil {
+			writeErr = err
+			return false, nil
+		}
+		if err := enc.Encode(evt); err != nil {
+			writeErr = fmt.Errorf("写出事件 %s 失败: %w", evt.ID, err)
+			return false, nil
+		}
+		count++
+		return false, nil
+	})
+	if err != nil {
+		return count, fmt.Errorf("读取事件失败: %w", err)
+	}
+	if writeErr != nil {
+		return count, writeErr
+	}
+	if err := bw.Flush(); err != nil {
+		return count, fmt.Errorf("写出事件失败: %w", err)
+	}
+	return count, nil
+}
+
+// JSONLImportOptions 从 JSONL 导入事件的选项
+type JSONLImportOptions struct {
+	// Accept 在保存前检查事件，返回错误时跳过该事件
+	Accept func(evt *nostr.Event) error
+	// Progress 每读取一批行以及结束时以当前统计调用
+	Progress func(stats ImportStats)
+}
+
+// ImportJSONL 逐行读取 ExportEvents 写出的格式，校验 ID 和签名、去重后通过 SaveEvent 保存。
+// 无法解析的行计入 Invalid 并跳过；读取或保存失败时返回已有的统计和错误。
+func (a *OrbitDBAdapter) ImportJSONL(ctx context.Context, r io.Reader, opts *JSONLImportOptions) (ImportStats, error) {
+	if opts == nil {
+		opts = &JSONLImportOptions{}
+	}
+	var stats ImportStats
+	report := func() {
+		if opts.Progress != nil {
+			opts.Progress(stats)
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), jsonlMaxLine)
+	lines := 0
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		lines++
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		stats.Fetched++
+		evt := &nostr.Event{}
+		if err := json.Unmarshal(line, evt); err != nil {
+			stats.Invalid++
+			a.metrics.Reject(RejectInvalid)
+		} else if err := a.importEvent(ctx, evt, opts.Accept, &stats); err != nil {
+			return stats, fmt.Errorf("第 %d 行: %w", lines, err)
+		}
+
+		if lines%jsonlProgressInterval == 0 {
+			report()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, fmt.Errorf("第 %d 行之后读取失败: %w", lines, err)
+	}
+	report()
+	return stats, nil
+}
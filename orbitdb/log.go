@@ -0,0 +1,24 @@
+package orbitdb
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// logger 本包组件使用的日志记录器，为 nil 时使用 slog.Default()
+var logger atomic.Pointer[slog.Logger]
+
+// SetLogger 设置本包组件的日志记录器。只影响之后创建的组件，应在创建适配器等组件之前调用；
+// 未设置时使用 slog.Default()。
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+}
+
+// componentLogger 返回带 component 字段的日志记录器
+func componentLogger(component string) *slog.Logger {
+	l := logger.Load()
+	if l == nil {
+		l = slog.Default()
+	}
+	return l.With("component", component)
+}
@@ -0,0 +1,229 @@
+package orbitdb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// MemoryStore 在内存中实现 Store 的参考实现，不需要 IPFS，用于测试中继逻辑。
+// 它不校验事件的 ID 和签名，也不持久化
+type MemoryStore struct {
+	mu     sync.RWMutex
+	events map[string]*nostr.Event
+	subs   map[*memorySub]struct{}
+	closed bool
+	// done 在 Close 时关闭，结束等待订阅 ctx 的 goroutine
+	done chan struct{}
+}
+
+// memorySub 一个 Subscribe 调用
+type memorySub struct {
+	filters nostr.Filters
+	events  chan *nostr.Event
+}
+
+// NewMemoryStore 创建一个空的内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		events: make(map[string]*nostr.Event),
+		subs:   make(map[*memorySub]struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// SaveEvent 保存 event 的副本，并发送给匹配的订阅
+func (m *MemoryStore) SaveEvent(ctx context.Context, event *nostr.Event) error {
+	if event == nil {
+		return fmt.Errorf("事件不能为空")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.save(event)
+	return nil
+}
+
+// save 保存 event 的副本并发送给匹配的订阅，调用方持有 m.mu
+func (m *MemoryStore) save(event *nostr.Event) {
+	m.events[event.ID] = copyEvent(event)
+	for sub := range m.subs {
+		if len(sub.filters) > 0 && !sub.filters.Match(event) {
+			continue
+		}
+		select {
+		case sub.events <- copyEvent(event):
+		default:
+			// 与 OrbitDBAdapter 一样，缓冲已满时结束订阅
+			m.removeSub(sub)
+		}
+	}
+}
+
+// QueryEvents 按 created_at 从新到旧发送匹配过滤器的事件，filter.Limit 大于 0 时最多发送这么多个
+func (m *MemoryStore) QueryEvents(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+	events := m.matching(filter)
+	sortEvents(events)
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+
+	ch := make(chan *nostr.Event)
+	go func() {
+		defer close(ch)
+		for _, evt := range events {
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- evt:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// QueryFilters 与 OrbitDBAdapter.QueryFilters 相同
+func (m *MemoryStore) QueryFilters(ctx context.Context, filters nostr.Filters) (chan *nostr.Event, error) {
+	matched := make([][]*nostr.Event, len(filters))
+	m.mu.RLock()
+	for _, evt := range m.events {
+		var c *nostr.Event
+		for i, filter := range filters {
+			if !filter.Matches(evt) {
+				continue
+			}
+			if c == nil {
+				c = copyEvent(evt)
+			}
+			matched[i] = append(matched[i], c)
+		}
+	}
+	m.mu.RUnlock()
+	events := mergeFilterResults(filters, matched)
+
+	ch := make(chan *nostr.Event)
+	go func() {
+		defer close(ch)
+		for _, evt := range events {
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- evt:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// CountEvents 返回匹配过滤器的事件数，不受 filter.Limit 限制
+func (m *MemoryStore) CountEvents(ctx context.Context, filter nostr.Filter) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return int64(len(m.matching(filter))), nil
+}
+
+// DeleteEvent 删除 ID 为 event.ID 的事件
+func (m *MemoryStore) DeleteEvent(ctx context.Context, event *nostr.Event) error {
+	if event == nil {
+		return fmt.Errorf("事件不能为空")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	if _, ok := m.events[event.ID]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, event.ID)
+	}
+	delete(m.events, event.ID)
+	return nil
+}
+
+// Subscribe 返回之后保存的、匹配任一过滤器的事件。ctx 结束、存储关闭，
+// 或者读取过慢使通道的缓冲已满时关闭通道
+func (m *MemoryStore) Subscribe(ctx context.Context, filters nostr.Filters) (chan *nostr.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	sub := &memorySub{filters: filters, events: make(chan *nostr.Event, subscribeQueueSize)}
+	m.subs[sub] = struct{}{}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-m.done:
+			// Close 已经结束了全部订阅
+			return
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.removeSub(sub)
+	}()
+	return sub.events, nil
+}
+
+// Close 停止接受写入并结束全部订阅
+func (m *MemoryStore) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	m.closed = true
+	close(m.done)
+	for sub := range m.subs {
+		m.removeSub(sub)
+	}
+}
+
+// Closed 报告存储是否已关闭
+func (m *MemoryStore) Closed() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.closed
+}
+
+// removeSub 结束订阅，调用方持有 m.mu
+func (m *MemoryStore) removeSub(sub *memorySub) {
+	if _, ok := m.subs[sub]; !ok {
+		return
+	}
+	delete(m.subs, sub)
+	close(sub.events)
+}
+
+// matching 返回匹配 filter 的事件副本，不排序
+func (m *MemoryStore) matching(filter nostr.Filter) []*nostr.Event {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var events []*nostr.Event
+	for _, evt := range m.events {
+		if filter.Matches(evt) {
+			events = append(events, copyEvent(evt))
+		}
+	}
+	return events
+}
+
+// copyEvent 返回 evt 的深拷贝，调用方修改返回的事件不会影响存储
+func copyEvent(evt *nostr.Event) *nostr.Event {
+	c := *evt
+	c.Tags = make(nostr.Tags, len(evt.Tags))
+	for i, tag := range evt.Tags {
+		c.Tags[i] = append(nostr.Tag(nil), tag...)
+	}
+	return &c
+}
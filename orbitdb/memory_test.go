@@ -0,0 +1,14 @@
+package orbitdb_test
+
+import (
+	"testing"
+
+	nostrstore "github.com/maoaixiao1314/orbitdb/orbitdb"
+	"github.com/maoaixiao1314/orbitdb/orbitdb/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) nostrstore.Store {
+		return nostrstore.NewMemoryStore()
+	})
+}
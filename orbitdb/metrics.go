@@ -0,0 +1,182 @@
+package orbitdb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 事件被拒绝的原因，用作 orbitdb_events_rejected_total 的 reason 标签
+const (
+	// RejectInvalid ID 或签名无效
+	RejectInvalid = "invalid"
+	// RejectPolicy 违反中继策略
+	RejectPolicy = "policy"
+	// RejectDuplicate 数据库中已有该事件
+	RejectDuplicate = "duplicate"
+	// RejectClosed 适配器已关闭
+	RejectClosed = "closed"
+	// RejectError 写入存储失败
+	RejectError = "error"
+)
+
+// Metrics 适配器、存储和复制的 Prometheus 指标
+type Metrics struct {
+	saved    prometheus.Counter
+	rejected *prometheus.CounterVec
+	queries  *prometheus.HistogramVec
+}
+
+// NewMetrics 在 reg 上注册 adapter 及其存储的指标，并让 adapter 开始记录写入和查询。
+// repl 不为 nil 时同时导出复制状态。应在开始使用 adapter 之前调用。
+func NewMetrics(reg prometheus.Registerer, adapter *OrbitDBAdapter, repl *ReplicationTracker) (*Metrics, error) {
+	m := &Metrics{
+		saved: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "orbitdb_events_saved_total",
+			Help: "Events saved to the store.",
+		}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "orbitdb_events_rejected_total",
+			Help: "Events not saved, by reason.",
+		}, []string{"reason"}),
+		queries: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "orbitdb_query_duration_seconds",
+			Help:    "Duration of queries, by the fields set in the filter.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 4, 10),
+		}, []string{"shape"}),
+	}
+	for _, reason := range []string{RejectInvalid, RejectPolicy, RejectDuplicate, RejectClosed, RejectError} {
+		m.rejected.WithLabelValues(reason)
+	}
+
+	db := adapter.db
+	collectors := []prometheus.Collector{
+		m.saved,
+		m.rejected,
+		m.queries,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "orbitdb_documents",
+			Help: "Documents in the store, not counting deleted ones.",
+		}, func() float64 { return float64(adapter.index.count()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "orbitdb_oplog_entries",
+			Help: "Entries of the operation log loaded in memory; after starting from an index snapshot, only those newer than the snapshot.",
+		}, func() float64 { return float64(db.OpLog().Len()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "orbitdb_oplog_heads",
+			Help: "Heads of the operation log.",
+		}, func() float64 { return float64(len(db.OpLog().Heads().Slice())) }),
+	}
+	if repl != nil {
+		collectors = append(collectors,
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: "orbitdb_replication_queued_entries",
+				Help: "Entries queued for replication.",
+			}, func() float64 { return float64(repl.Status().Queued) }),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: "orbitdb_replication_lag_seconds",
+				Help: "Time since the store last held every head announced by its peers, 0 while it does.",
+			}, func() float64 { return repl.Lag().Seconds() }),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: "orbitdb_replication_peers_behind",
+				Help: "Peers that announced heads missing from the local log.",
+			}, func() float64 {
+				behind := 0
+				for _, p := range repl.Status().Peers {
+					if !p.Synced {
+						behind++
+					}
+				}
+				return float64(behind)
+			}),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "orbitdb_pubsub_messages_total",
+				Help:        "Heads messages on the database topic.",
+				ConstLabels: prometheus.Labels{"direction": "in"},
+			}, func() float64 { return float64(repl.Status().MessagesIn) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "orbitdb_pubsub_messages_total",
+				Help:        "Heads messages on the database topic.",
+				ConstLabels: prometheus.Labels{"direction": "out"},
+			}, func() float64 { return float64(repl.Status().MessagesOut) }),
+		)
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("无法注册指标: %w", err)
+		}
+	}
+
+	adapter.metrics = m
+	return m, nil
+}
+
+// Reject 记录一个未保存的事件，供在调用 SaveEvent 之前做检查的调用方使用
+func (m *Metrics) Reject(reason string) {
+	if m == nil {
+		return
+	}
+	m.rejected.WithLabelValues(reason).Inc()
+}
+
+// savedEvent 记录一个已保存的事件
+func (m *Metrics) savedEvent() {
+	if m == nil {
+		return
+	}
+	m.saved.Inc()
+}
+
+// observeQuery 记录一次查询的耗时，shape 由 filterShape 或 filtersShape 得到
+func (m *Metrics) observeQuery(shape string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.queries.WithLabelValues(shape).Observe(time.Since(start).Seconds())
+}
+
+// filtersShape 返回一次查询多个过滤器的 shape 标签：只有一个过滤器时与 filterShape 相同，
+// 否则为 "multi"，一次 REQ 只记录一次耗时
+func filtersShape(filters nostr.Filters) string {
+	if len(filters) == 1 {
+		return filterShape(filters[0])
+	}
+	return "multi"
+}
+
+// filterShape 返回过滤器中设置了哪些字段，如 "authors+kinds"，未设置任何字段时为 "all"。
+// 只取字段名而不取值，标签的取值有限。
+func filterShape(filter nostr.Filter) string {
+	var parts []string
+	if len(filter.IDs) > 0 {
+		parts = append(parts, "ids")
+	}
+	if len(filter.Authors) > 0 {
+		parts = append(parts, "authors")
+	}
+	if len(filter.Kinds) > 0 {
+		parts = append(parts, "kinds")
+	}
+	if len(filter.Tags) > 0 {
+		parts = append(parts, "tags")
+	}
+	if filter.Since != nil {
+		parts = append(parts, "since")
+	}
+	if filter.Until != nil {
+		parts = append(parts, "until")
+	}
+	if filter.Limit > 0 {
+		parts = append(parts, "limit")
+	}
+	if filter.Search != "" {
+		parts = append(parts, "search")
+	}
+	if len(parts) == 0 {
+		return "all"
+	}
+	return strings.Join(parts, "+")
+}
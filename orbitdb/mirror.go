@@ -0,0 +1,614 @@
+package orbitdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-orbit-db/stores"
+	"berty.tech/go-orbit-db/stores/operation"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/nbd-wtf/go-nostr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultMirrorQueueSize 每个中继待发布事件队列的默认长度
+	DefaultMirrorQueueSize = 10000
+	// DefaultMirrorAttempts 单个事件默认的最大发布次数
+	DefaultMirrorAttempts = 5
+
+	mirrorPublishTimeout = 10 * time.Second
+	mirrorMinBackoff     = time.Second
+	mirrorMaxBackoff     = 2 * time.Minute
+	mirrorSaveInterval   = 5 * time.Second
+)
+
+// MirrorOptions 将事件转发到外部中继的选项
+type MirrorOptions struct {
+	// Relays 目标中继的 URL
+	Relays []string
+	// Filters 只转发匹配的事件，为空时转发全部事件
+	Filters nostr.Filters
+	// CursorFile 保存每个中继游标的文件，为空时不保存
+	CursorFile string
+	// QueueSize 每个中继的队列长度，为 0 时使用 DefaultMirrorQueueSize
+	QueueSize int
+	// MaxAttempts 单个事件的最大发布次数，为 0 时使用 DefaultMirrorAttempts
+	MaxAttempts int
+}
+
+// MirrorStatus 一个目标中继的转发状态。Cursor 是可能尚未发布的事件中最早的 created_at，补发从这里开始
+type MirrorStatus struct {
+	Relay     string          `json:"relay"`
+	Connected bool            `json:"connected"`
+	Queued    int             `json:"queued"`
+	Published int             `json:"published"`
+	Rejected  int             `json:"rejected"`
+	Failed    int             `json:"failed"`
+	Dropped   int             `json:"dropped"`
+	Cursor    nostr.Timestamp `json:"cursor"`
+	LastError string          `json:"last_error,omitempty"`
+}
+
+// Mirror 监听本地写入和复制进来的事件，并发布到一组外部中继。
+// 每个中继有独立的队列和游标。游标不超过已确认事件中最新的 created_at，
+// 也不超过仍在队列中、等待重发或因队列溢出被丢弃的任何事件的 created_at；
+// 重启或队列溢出后，从游标开始重新发布本地的事件，中继会按 ID 去重。
+type Mirror struct {
+	adapter     *OrbitDBAdapter
+	log         *slog.Logger
+	filters     nostr.Filters
+	cursorFile  string
+	maxAttempts int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	relays []*mirrorRelay
+}
+
+// mirrorRelay 一个目标中继的队列与状态
+type mirrorRelay struct {
+	url   string
+	queue chan *nostr.Event
+	// catchUp 有事件因队列溢出被丢弃，需要从游标重新发布
+	catchUp chan struct{}
+	// pending 已从队列取出但尚未发布完的事件数
+	pending atomic.Int64
+
+	mu     sync.Mutex
+	status MirrorStatus
+	// acked 已确认事件中最新的 created_at
+	acked nostr.Timestamp
+	// unacked 已入队或待补发、还没有结果的事件按 created_at 计数
+	unacked map[nostr.Timestamp]int
+	// dropped 因队列溢出被丢弃、还没有补发的事件中最早的 created_at，0 表示没有
+	dropped nostr.Timestamp
+	// drops 丢弃事件的次数，补发期间有新的丢弃时不清除 dropped
+	drops int
+	// saved 上次写入文件的游标
+	saved nostr.Timestamp
+}
+
+// NewMirror 开始将 adapter 中新增的事件转发到 opts.Relays，直到调用 Close
+func NewMirror(ctx context.Context, adapter *OrbitDBAdapter, opts *MirrorOptions) (*Mirror, error) {
+	queueSize := opts.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultMirrorQueueSize
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMirrorAttempts
+	}
+
+	cursors, err := loadMirrorCursors(opts.CursorFile)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := adapter.db.EventBus().Subscribe([]interface{}{
+		new(stores.EventWrite),
+		new(stores.EventReplicated),
+	}, eventbus.Name("orbitdb/mirror"), eventbus.BufSize(128))
+	if err != nil {
+		return nil, fmt.Errorf("无法订阅存储事件: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	m := &Mirror{
+		adapter:     adapter,
+		log:         componentLogger("mirror"),
+		filters:     opts.Filters,
+		cursorFile:  opts.CursorFile,
+		maxAttempts: maxAttempts,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+
+	now := nostr.Now()
+	for _, url := range opts.Relays {
+		r := &mirrorRelay{
+			url:     url,
+			queue:   make(chan *nostr.Event, queueSize),
+			catchUp: make(chan struct{}, 1),
+			status:  MirrorStatus{Relay: url},
+			unacked: make(map[nostr.Timestamp]int),
+		}
+		// 第一次转发到该中继时从现在开始，不补发已有的全部事件
+		if cursor, ok := cursors[nostr.NormalizeURL(url)]; ok {
+			r.acked, r.saved = cursor, cursor
+			r.catchUp <- struct{}{}
+		} else {
+			r.acked = now
+		}
+		m.relays = append(m.relays, r)
+	}
+
+	m.wg.Add(2 + len(m.relays))
+	go func() {
+		defer m.wg.Done()
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-sub.Out():
+				m.handleStoreEvent(e)
+			}
+		}
+	}()
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(mirrorSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.saveCursors(); err != nil {
+					m.log.Warn("failed to save mirror cursors", "err", err)
+				}
+			}
+		}
+	}()
+	for _, r := range m.relays {
+		go func(r *mirrorRelay) {
+			defer m.wg.Done()
+			m.run(r)
+		}(r)
+	}
+
+	return m, nil
+}
+
+// Status 返回每个目标中继的转发状态
+func (m *Mirror) Status() []MirrorStatus {
+	statuses := make([]MirrorStatus, 0, len(m.relays))
+	for _, r := range m.relays {
+		r.mu.Lock()
+		st := r.status
+		st.Cursor = r.cursor()
+		r.mu.Unlock()
+		st.Queued = len(r.queue)
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// Flush 等待所有中继的队列发布完毕，或 ctx 结束
+func (m *Mirror) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		idle := true
+		for _, r := range m.relays {
+			if len(r.queue) > 0 || len(r.catchUp) > 0 || r.pending.Load() > 0 {
+				idle = false
+				break
+			}
+		}
+		if idle {
+			return nil
+		}
+		if m.ctx.Err() != nil {
+			return m.ctx.Err()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close 停止转发并保存游标。队列中和等待重发的事件都不早于游标，下次启动时从游标补发
+func (m *Mirror) Close() error {
+	m.cancel()
+	m.wg.Wait()
+	return m.saveCursors()
+}
+
+// handleStoreEvent 从写入或复制的日志条目中取出事件并加入各中继的队列
+func (m *Mirror) handleStoreEvent(e interface{}) {
+	for _, evt := range storeEvents(e) {
+		if len(m.filters) > 0 && !m.filters.Match(evt) {
+			continue
+		}
+		if !validEvent(evt) {
+			continue
+		}
+		for _, r := range m.relays {
+			r.enqueue(evt)
+		}
+	}
+}
+
+// storeEvents 返回写入或复制事件带来的日志条目中保存的事件
+func storeEvents(e interface{}) []*nostr.Event {
+	var entries []ipfslog.Entry
+	switch evt := e.(type) {
+	case stores.EventWrite:
+		entries = []ipfslog.Entry{evt.Entry}
+	case stores.EventReplicated:
+		entries = evt.Entries
+	default:
+		return nil
+	}
+
+	var events []*nostr.Event
+	for _, entry := range entries {
+		events = append(events, entryEvents(entry)...)
+	}
+	return events
+}
+
+// entryEvents 解析日志条目中 PUT/PUTALL 操作保存的事件
+func entryEvents(entry ipfslog.Entry) []*nostr.Event {
+	if entry == nil {
+		return nil
+	}
+	op, err := operation.ParseOperation(entry)
+	if err != nil {
+		return nil
+	}
+
+	var values [][]byte
+	switch op.GetOperation() {
+	case "PUT":
+		values = append(values, op.GetValue())
+	case "PUTALL":
+		for _, doc := range op.GetDocs() {
+			values = append(values, doc.GetValue())
+		}
+	}
+
+	var events []*nostr.Event
+	for _, value := range values {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(value, &doc); err != nil {
+			continue
+		}
+		events = append(events, docToEvent(doc))
+	}
+	return events
+}
+
+// enqueue 将事件加入队列；队列已满时丢弃，游标停在它之前，并安排一次从游标开始的补发
+func (r *mirrorRelay) enqueue(evt *nostr.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case r.queue <- evt:
+		r.unacked[evt.CreatedAt]++
+	default:
+		r.status.Dropped++
+		r.drops++
+		if r.dropped == 0 || evt.CreatedAt < r.dropped {
+			r.dropped = evt.CreatedAt
+		}
+		select {
+		case r.catchUp <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run 连接中继并依次发布队列中的事件，断线后按指数退避重连
+func (m *Mirror) run(r *mirrorRelay) {
+	var relay *relayConn
+	defer func() {
+		if relay != nil {
+			relay.close()
+		}
+	}()
+
+	// pending 是断线时尚未发布完的事件，重连后先发布它们
+	var pending []*nostr.Event
+	failures := 0
+	for {
+		if relay == nil || !relay.connected() {
+			r.setConnected(false)
+			if relay != nil {
+				relay.close()
+				relay = nil
+			}
+			conn, err := dialRelay(m.ctx, r.url)
+			if err != nil {
+				if m.ctx.Err() != nil {
+					return
+				}
+				failures++
+				r.setError(err)
+				if !m.sleep(mirrorBackoff(failures)) {
+					return
+				}
+				continue
+			}
+			relay, failures = conn, 0
+			r.setConnected(true)
+		}
+
+		if len(pending) == 0 {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-r.catchUp:
+				pending = m.catchUpEvents(r)
+			case evt := <-r.queue:
+				pending = []*nostr.Event{evt}
+			}
+		}
+		r.pending.Store(int64(len(pending)))
+		for len(pending) > 0 && m.publish(relay, r, pending[0]) {
+			pending = pending[1:]
+			r.pending.Store(int64(len(pending)))
+		}
+		if m.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// publish 发布一个事件，失败时重试至多 maxAttempts 次。
+// 连接断开时返回 false，由调用方重连后再次发布。
+func (m *Mirror) publish(relay *relayConn, r *mirrorRelay, evt *nostr.Event) bool {
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(m.ctx, mirrorPublishTimeout)
+		ctx, span := tracer.Start(ctx, "nostr.EVENT", trace.WithAttributes(
+			append(eventAttributes(evt),
+				attribute.String("nostr.relay", r.url),
+				attribute.Int("nostr.attempt", attempt))...))
+		ok, err := relay.publish(ctx, evt)
+		status := "succeeded"
+		if err != nil {
+			status = "failed"
+		} else if !ok.OK {
+			status = "rejected"
+		}
+		span.SetAttributes(attribute.String("nostr.publish_status", status))
+		endSpan(span, err)
+		cancel()
+
+		switch {
+		case err != nil:
+			// 超时或连接断开，在下面重试或交给调用方重连
+		case ok.OK, strings.HasPrefix(ok.Reason, "duplicate:"):
+			// 中继保存了事件，或者已经有这个事件
+			r.published(evt)
+			return true
+		default:
+			// 中继明确拒绝，重试也不会成功
+			r.update(func(st *MirrorStatus) {
+				st.Rejected++
+				st.LastError = ok.Reason
+			})
+			r.finished(evt)
+			return true
+		}
+
+		if m.ctx.Err() != nil || !relay.connected() {
+			return false
+		}
+		r.setError(err)
+		if attempt >= m.maxAttempts {
+			r.update(func(st *MirrorStatus) { st.Failed++ })
+			r.finished(evt)
+			m.log.Warn("giving up on mirroring event", "relay", r.url, "event", evt.ID, "attempts", attempt, "err", err)
+			return true
+		}
+		if !m.sleep(mirrorBackoff(attempt)) {
+			return false
+		}
+	}
+}
+
+// catchUpEvents 返回本地 created_at 不早于游标的事件，按 created_at 从旧到新排列。
+// 返回的事件计入未确认的事件，之后游标不再需要停在被丢弃的事件之前
+func (m *Mirror) catchUpEvents(r *mirrorRelay) []*nostr.Event {
+	r.mu.Lock()
+	cursor := r.cursor()
+	drops := r.drops
+	r.mu.Unlock()
+
+	events, err := m.adapter.QueryEvents(m.ctx, nostr.Filter{Since: &cursor})
+	if err != nil {
+		// 保留 dropped，游标停在被丢弃的事件之前，下次补发时再发布
+		r.setError(err)
+		return nil
+	}
+	var result []*nostr.Event
+	for evt := range events {
+		if evt.CreatedAt < cursor {
+			continue
+		}
+		if len(m.filters) > 0 && !m.filters.Match(evt) {
+			continue
+		}
+		result = append(result, evt)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt < result[j].CreatedAt })
+
+	r.mu.Lock()
+	for _, evt := range result {
+		r.unacked[evt.CreatedAt]++
+	}
+	if r.drops == drops {
+		r.dropped = 0
+	}
+	r.mu.Unlock()
+	return result
+}
+
+// sleep 等待 d，ctx 结束时返回 false
+func (m *Mirror) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-m.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// saveCursors 将有变化的游标写入文件
+func (m *Mirror) saveCursors() error {
+	if m.cursorFile == "" {
+		return nil
+	}
+
+	dirty := false
+	cursors := make(map[string]nostr.Timestamp, len(m.relays))
+	for _, r := range m.relays {
+		r.mu.Lock()
+		cursor := r.cursor()
+		dirty = dirty || cursor != r.saved
+		r.mu.Unlock()
+		cursors[nostr.NormalizeURL(r.url)] = cursor
+	}
+	if !dirty {
+		return nil
+	}
+
+	// 保留文件中其他中继的游标
+	existing, err := loadMirrorCursors(m.cursorFile)
+	if err != nil {
+		return err
+	}
+	for url, cursor := range cursors {
+		existing[url] = cursor
+	}
+
+	data, err := json.MarshalIndent(existing, "", "  ")
+	if err != nil {
+		return fmt.Errorf("无法编码游标: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(m.cursorFile), 0755); err != nil {
+		return fmt.Errorf("无法创建游标目录: %w", err)
+	}
+	tmp := m.cursorFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("无法写入游标文件: %w", err)
+	}
+	if err := os.Rename(tmp, m.cursorFile); err != nil {
+		return fmt.Errorf("无法写入游标文件: %w", err)
+	}
+	for _, r := range m.relays {
+		r.update(func(*MirrorStatus) { r.saved = cursors[nostr.NormalizeURL(r.url)] })
+	}
+	return nil
+}
+
+// loadMirrorCursors 读取游标文件，文件不存在时返回空的游标
+func loadMirrorCursors(path string) (map[string]nostr.Timestamp, error) {
+	cursors := make(map[string]nostr.Timestamp)
+	if path == "" {
+		return cursors, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cursors, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("无法读取游标文件: %w", err)
+	}
+	if err := json.Unmarshal(data, &cursors); err != nil {
+		return nil, fmt.Errorf("游标文件 %s 格式错误: %w", path, err)
+	}
+	return cursors, nil
+}
+
+func (r *mirrorRelay) update(fn func(st *MirrorStatus)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(&r.status)
+}
+
+func (r *mirrorRelay) published(evt *nostr.Event) {
+	r.update(func(st *MirrorStatus) {
+		st.Published++
+		st.LastError = ""
+		if evt.CreatedAt > r.acked {
+			r.acked = evt.CreatedAt
+		}
+	})
+	r.finished(evt)
+}
+
+// finished 将事件移出未确认的事件：它已发布、被中继拒绝或放弃重试
+func (r *mirrorRelay) finished(evt *nostr.Event) {
+	r.update(func(*MirrorStatus) {
+		if n := r.unacked[evt.CreatedAt]; n > 1 {
+			r.unacked[evt.CreatedAt] = n - 1
+		} else {
+			delete(r.unacked, evt.CreatedAt)
+		}
+	})
+}
+
+// cursor 返回可能尚未发布的事件中最早的 created_at，调用方持有 r.mu
+func (r *mirrorRelay) cursor() nostr.Timestamp {
+	cursor := r.acked
+	for ts := range r.unacked {
+		if ts < cursor {
+			cursor = ts
+		}
+	}
+	if r.dropped != 0 && r.dropped < cursor {
+		cursor = r.dropped
+	}
+	return cursor
+}
+
+func (r *mirrorRelay) setConnected(connected bool) {
+	r.update(func(st *MirrorStatus) { st.Connected = connected })
+}
+
+func (r *mirrorRelay) setError(err error) {
+	r.update(func(st *MirrorStatus) { st.LastError = err.Error() })
+}
+
+// mirrorBackoff 第 attempts 次失败后的等待时间
+func mirrorBackoff(attempts int) time.Duration {
+	if attempts > 10 {
+		return mirrorMaxBackoff
+	}
+	delay := mirrorMinBackoff << (attempts - 1)
+	if delay > mirrorMaxBackoff {
+		delay = mirrorMaxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,135 @@
+package orbitdb_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	nostrstore "github.com/maoaixiao1314/orbitdb/orbitdb"
+)
+
+// startMirror 开始将 store 的新事件转发到 url，测试结束时关闭
+func startMirror(ctx context.Context, t *testing.T, store adapterStore, url, cursorFile string) *nostrstore.Mirror {
+	t.Helper()
+	m, err := nostrstore.NewMirror(ctx, store.OrbitDBAdapter, &nostrstore.MirrorOptions{
+		Relays:     []string{url},
+		CursorFile: cursorFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// savedCursor 读取游标文件中 url 的游标
+func savedCursor(t *testing.T, path, url string) nostr.Timestamp {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cursors map[string]nostr.Timestamp
+	if err := json.Unmarshal(data, &cursors); err != nil {
+		t.Fatal(err)
+	}
+	cursor, ok := cursors[nostr.NormalizeURL(url)]
+	if !ok {
+		t.Fatalf("游标文件中没有 %s", url)
+	}
+	return cursor
+}
+
+func TestMirrorCursor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := openDocs(ctx, t, testOrbitDB(ctx, t), "mirror-cursor")
+	defer store.Close()
+	relay, url := newTestRelay(t)
+	cursorFile := filepath.Join(t.TempDir(), "mirror-cursors.json")
+
+	now := nostr.Now()
+	events := signedEvents(t, now+100, now+101, now+102, now-100)
+
+	m := startMirror(ctx, t, store, url, cursorFile)
+	saveEvents(t, store, events[0], events[1])
+	waitFor(t, "转发两个事件", func() bool { return len(relay.publishCount()) == 2 })
+	if err := m.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if st := m.Status()[0]; st.Published != 2 || st.Cursor != events[1].CreatedAt {
+		t.Errorf("发布 %d 个事件、游标 %d，应为 2 个和 %d", st.Published, st.Cursor, events[1].CreatedAt)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if cursor := savedCursor(t, cursorFile, url); cursor != events[1].CreatedAt {
+		t.Errorf("保存的游标为 %d，应为 %d", cursor, events[1].CreatedAt)
+	}
+
+	// 停止期间保存的事件在重启后从游标开始补发，早于游标的事件不补发
+	saveEvents(t, store, events[2], events[3])
+	m = startMirror(ctx, t, store, url, cursorFile)
+	waitFor(t, "补发停止期间保存的事件", func() bool { return relay.publishCount()[events[2].ID] == 1 })
+	if err := m.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if relay.publishCount()[events[3].ID] != 0 {
+		t.Error("补发了早于游标的事件")
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if cursor := savedCursor(t, cursorFile, url); cursor != events[2].CreatedAt {
+		t.Errorf("补发后保存的游标为 %d，应为 %d", cursor, events[2].CreatedAt)
+	}
+}
+
+func TestMirrorRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := openDocs(ctx, t, testOrbitDB(ctx, t), "mirror-retry")
+	defer store.Close()
+	relay, url := newTestRelay(t)
+
+	now := nostr.Now()
+	events := signedEvents(t, now+100, now+101, now+102)
+	relay.mu.Lock()
+	// 第一个事件发布时断开连接，第二个中继已经有了，第三个被拒绝
+	relay.dropEvent = 1
+	relay.reject = map[string]string{
+		events[1].ID: "duplicate: already have it",
+		events[2].ID: "blocked: not allowed",
+	}
+	relay.mu.Unlock()
+
+	m := startMirror(ctx, t, store, url, "")
+	saveEvents(t, store, events...)
+	waitFor(t, "发布全部事件", func() bool {
+		st := m.Status()[0]
+		return st.Published+st.Rejected == len(events)
+	})
+
+	st := m.Status()[0]
+	if st.Published != 2 || st.Rejected != 1 || st.Failed != 0 {
+		t.Errorf("发布 %d 个、拒绝 %d 个、失败 %d 个，应为 2、1、0", st.Published, st.Rejected, st.Failed)
+	}
+	if st.LastError != "blocked: not allowed" {
+		t.Errorf("最后的错误为 %q，应为中继给出的拒绝原因", st.LastError)
+	}
+	// 被拒绝的事件不再阻挡游标，游标停在已确认事件中最新的一个
+	if st.Cursor != events[1].CreatedAt {
+		t.Errorf("游标为 %d，应为 %d", st.Cursor, events[1].CreatedAt)
+	}
+	counts := relay.publishCount()
+	if counts[events[0].ID] != 2 {
+		t.Errorf("断线时的事件发布了 %d 次，应在重连后重发一次", counts[events[0].ID])
+	}
+	if counts[events[2].ID] != 1 {
+		t.Errorf("被拒绝的事件发布了 %d 次，不应重试", counts[events[2].ID])
+	}
+}
@@ -0,0 +1,541 @@
+package orbitdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ReconcileProtocol 比较两个副本事件集合的 libp2p 协议
+const ReconcileProtocol protocol.ID = "/orbitdb/reconcile/1.0.0"
+
+const (
+	// DefaultBucketSize 按 created_at 分桶的默认宽度（秒）
+	DefaultBucketSize int64 = 24 * 60 * 60
+
+	// reconcileBatch 单次请求最多取回的事件数
+	reconcileBatch = 256
+
+	reconcileRequestLimit  = 4 << 20
+	reconcileResponseLimit = 64 << 20
+	reconcileStreamTimeout = time.Minute
+)
+
+const (
+	reconcileOpSummary = "summary"
+	reconcileOpIDs     = "ids"
+	reconcileOpEvents  = "events"
+)
+
+// BucketSummary 一个 created_at 区间内事件 ID 集合的摘要
+type BucketSummary struct {
+	Start int64  `json:"start"`
+	Count int    `json:"count"`
+	Hash  string `json:"hash"`
+}
+
+// ReconcileReport 与一个对端进行一次对账的结果
+type ReconcileReport struct {
+	Peer    peer.ID   `json:"peer"`
+	Address string    `json:"address"`
+	Time    time.Time `json:"time"`
+
+	// LocalRoot 与 RemoteRoot 是全部分桶摘要的哈希，相等即两个副本持有相同的事件集合
+	LocalRoot    string `json:"local_root"`
+	RemoteRoot   string `json:"remote_root"`
+	LocalEvents  int    `json:"local_events"`
+	RemoteEvents int    `json:"remote_events"`
+
+	// DivergentBuckets 摘要不一致的分桶数
+	DivergentBuckets int `json:"divergent_buckets"`
+	// Missing 对端有而本地没有的事件数
+	Missing int `json:"missing"`
+	// Extra 本地有而对端没有的事件数，由对端向本地对账时拉取
+	Extra int `json:"extra"`
+	// Pulled 本次拉取并保存的事件数
+	Pulled int `json:"pulled"`
+	// Skipped 本地已删除或未通过校验而未保存的事件数
+	Skipped int `json:"skipped"`
+	// Rejected 未通过 ReconcileOptions.Accept 检查而未保存的事件数
+	Rejected int `json:"rejected"`
+
+	Converged bool          `json:"converged"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// reconcileRequest 对账协议的请求，每个流一个
+type reconcileRequest struct {
+	Address    string   `json:"address"`
+	Op         string   `json:"op"`
+	BucketSize int64    `json:"bucket_size"`
+	Buckets    []int64  `json:"buckets,omitempty"`
+	IDs        []string `json:"ids,omitempty"`
+}
+
+// reconcileResponse 对账协议的应答
+type reconcileResponse struct {
+	Buckets []BucketSummary `json:"buckets,omitempty"`
+	IDs     []string        `json:"ids,omitempty"`
+	Events  []*nostr.Event  `json:"events,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Reconciler 定期与对端比较事件 ID 集合并拉取缺失的事件。
+// 集合按 created_at 分桶，先比较每个桶的摘要，只对不一致的桶交换 ID，
+// 因此在日志同步之外给出一个可验证的收敛判断以及副本之间的差异报告。
+type Reconciler struct {
+	host   host.Host
+	log    *slog.Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	bucketSize int64
+	accept     func(evt *nostr.Event) error
+
+	mu       sync.RWMutex
+	adapters map[string]*OrbitDBAdapter
+	reports  map[peer.ID]map[string]*ReconcileReport
+}
+
+// ReconcileOptions 对账的选项
+type ReconcileOptions struct {
+	// BucketSize 分桶宽度（秒），为 0 时使用 DefaultBucketSize
+	BucketSize int64
+	// Accept 在保存前检查通过了 ID 和签名校验的拉取事件，返回错误时跳过该事件
+	Accept func(evt *nostr.Event) error
+}
+
+// NewReconciler 在 h 上注册 ReconcileProtocol，直到调用 Close。opts 可以为 nil。
+func NewReconciler(ctx context.Context, h host.Host, opts *ReconcileOptions) *Reconciler {
+	if opts == nil {
+		opts = &ReconcileOptions{}
+	}
+	bucketSize := opts.BucketSize
+	if bucketSize <= 0 {
+		bucketSize = DefaultBucketSize
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r := &Reconciler{
+		host:       h,
+		log:        componentLogger("reconcile"),
+		ctx:        ctx,
+		cancel:     cancel,
+		bucketSize: bucketSize,
+		accept:     opts.Accept,
+		adapters:   make(map[string]*OrbitDBAdapter),
+		reports:    make(map[peer.ID]map[string]*ReconcileReport),
+	}
+	h.SetStreamHandler(ReconcileProtocol, r.handleStream)
+	return r
+}
+
+// Register 开始为 adapter 所在的数据库应答对账请求，并在定期对账中包含它
+func (r *Reconciler) Register(adapter *OrbitDBAdapter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.adapters[adapter.db.Address().String()] = adapter
+}
+
+// Start 每隔 interval 与所有支持该协议的已连接对端对账一次，直到 Close
+func (r *Reconciler) Start(interval time.Duration) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			r.reconcileAll()
+		}
+	}()
+}
+
+// Close 移除协议处理器并等待进行中的对账结束
+func (r *Reconciler) Close() {
+	r.host.RemoveStreamHandler(ReconcileProtocol)
+	r.cancel()
+	r.wg.Wait()
+}
+
+// Reports 返回与每个对端最近一次对账的报告
+func (r *Reconciler) Reports() []ReconcileReport {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var reports []ReconcileReport
+	for _, byAddress := range r.reports {
+		for _, report := range byAddress {
+			reports = append(reports, *report)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Peer != reports[j].Peer {
+			return reports[i].Peer < reports[j].Peer
+		}
+		return reports[i].Address < reports[j].Address
+	})
+	return reports
+}
+
+// reconcileAll 与所有支持该协议的已连接对端对账
+func (r *Reconciler) reconcileAll() {
+	r.mu.RLock()
+	adapters := make([]*OrbitDBAdapter, 0, len(r.adapters))
+	for _, adapter := range r.adapters {
+		adapters = append(adapters, adapter)
+	}
+	r.mu.RUnlock()
+
+	for _, p := range r.host.Network().Peers() {
+		if ok, _ := r.host.Peerstore().SupportsProtocols(p, ReconcileProtocol); len(ok) == 0 {
+			continue
+		}
+		for _, adapter := range adapters {
+			report, err := r.Reconcile(r.ctx, p, adapter)
+			if r.ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				r.log.Warn("reconciliation failed", "peer", p, "err", err)
+				continue
+			}
+			if !report.Converged {
+				r.log.Info("reconciled diverging event sets", "peer", p,
+					"buckets", report.DivergentBuckets, "missing", report.Missing, "pulled", report.Pulled, "extra", report.Extra)
+			}
+		}
+	}
+}
+
+// Reconcile 与对端 p 比较 adapter 所在数据库的事件集合，拉取本地缺少且未删除的事件。
+// 即使返回错误，报告也会记录下来供 Reports 查询。
+func (r *Reconciler) Reconcile(ctx context.Context, p peer.ID, adapter *OrbitDBAdapter) (*ReconcileReport, error) {
+	start := time.Now()
+	address := adapter.db.Address().String()
+	report := &ReconcileReport{Peer: p, Address: address, Time: start}
+
+	err := r.reconcile(ctx, p, adapter, report)
+	report.Duration = time.Since(start)
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	if r.reports[p] == nil {
+		r.reports[p] = make(map[string]*ReconcileReport)
+	}
+	r.reports[p][address] = report
+	r.mu.Unlock()
+
+	return report, err
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, p peer.ID, adapter *OrbitDBAdapter, report *ReconcileReport) error {
+	address := adapter.db.Address().String()
+
+	local, err := adapter.eventIndex(ctx, r.bucketSize)
+	if err != nil {
+		return err
+	}
+	localSummaries := summarize(local)
+	report.LocalRoot = rootHash(localSummaries)
+	report.LocalEvents = local.count()
+
+	resp, err := r.request(ctx, p, &reconcileRequest{Address: address, Op: reconcileOpSummary, BucketSize: r.bucketSize})
+	if err != nil {
+		return err
+	}
+	report.RemoteRoot = rootHash(resp.Buckets)
+	for _, b := range resp.Buckets {
+		report.RemoteEvents += b.Count
+	}
+	if report.LocalRoot == report.RemoteRoot {
+		report.Converged = true
+		return nil
+	}
+
+	// 找出摘要不一致的分桶
+	remoteHashes := make(map[int64]string, len(resp.Buckets))
+	for _, b := range resp.Buckets {
+		remoteHashes[b.Start] = b.Hash
+	}
+	var divergent []int64
+	for _, b := range localSummaries {
+		if remoteHashes[b.Start] != b.Hash {
+			divergent = append(divergent, b.Start)
+		}
+		delete(remoteHashes, b.Start)
+	}
+	for start := range remoteHashes {
+		divergent = append(divergent, start)
+	}
+	report.DivergentBuckets = len(divergent)
+
+	resp, err = r.request(ctx, p, &reconcileRequest{Address: address, Op: reconcileOpIDs, BucketSize: r.bucketSize, Buckets: divergent})
+	if err != nil {
+		return err
+	}
+	remote := make(map[string]bool, len(resp.IDs))
+	for _, id := range resp.IDs {
+		remote[id] = true
+	}
+	for _, start := range divergent {
+		for _, id := range local[start] {
+			if !remote[id] {
+				report.Extra++
+			}
+			delete(remote, id)
+		}
+	}
+
+	var missing []string
+	for id := range remote {
+		missing = append(missing, id)
+	}
+	sort.Strings(missing)
+	report.Missing = len(missing)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	// 本地删除过的事件不再拉回；删除后又重新保存的事件在本地存在，不会出现在 missing 中
+	deleted := adapter.index.deleted()
+	missing = slices.DeleteFunc(missing, func(id string) bool {
+		if deleted[id] {
+			report.Skipped++
+			return true
+		}
+		return false
+	})
+
+	for len(missing) > 0 {
+		batch := missing[:min(len(missing), reconcileBatch)]
+		missing = missing[len(batch):]
+
+		resp, err := r.request(ctx, p, &reconcileRequest{Address: address, Op: reconcileOpEvents, IDs: batch})
+		if err != nil {
+			return err
+		}
+		for _, evt := range resp.Events {
+			if evt == nil || !slices.Contains(batch, evt.ID) || !validEvent(evt) {
+				report.Skipped++
+				continue
+			}
+			if r.accept != nil {
+				if err := r.accept(evt); err != nil {
+					report.Rejected++
+					adapter.metrics.Reject(RejectPolicy)
+					continue
+				}
+			}
+			if err := adapter.SaveEvent(ctx, evt); err != nil {
+				return fmt.Errorf("保存事件 %s 失败: %w", evt.ID, err)
+			}
+			report.Pulled++
+		}
+	}
+	return nil
+}
+
+// request 向对端 p 发送一个请求并读取应答
+func (r *Reconciler) request(ctx context.Context, p peer.ID, req *reconcileRequest) (*reconcileResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, reconcileStreamTimeout)
+	defer cancel()
+
+	s, err := r.host.NewStream(ctx, p, ReconcileProtocol)
+	if err != nil {
+		return nil, fmt.Errorf("无法打开到 %s 的流: %w", p, err)
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(s).Encode(req); err != nil {
+		s.Reset()
+		return nil, fmt.Errorf("发送请求失败: %w", err)
+	}
+	if err := s.CloseWrite(); err != nil {
+		s.Reset()
+		return nil, fmt.Errorf("发送请求失败: %w", err)
+	}
+
+	resp := &reconcileResponse{}
+	if err := json.NewDecoder(io.LimitReader(s, reconcileResponseLimit)).Decode(resp); err != nil {
+		s.Reset()
+		return nil, fmt.Errorf("读取应答失败: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("对端 %s 拒绝请求: %s", p, resp.Error)
+	}
+	return resp, nil
+}
+
+// handleStream 应答一个对账请求
+func (r *Reconciler) handleStream(s network.Stream) {
+	defer s.Close()
+	_ = s.SetDeadline(time.Now().Add(reconcileStreamTimeout))
+
+	req := &reconcileRequest{}
+	if err := json.NewDecoder(io.LimitReader(s, reconcileRequestLimit)).Decode(req); err != nil {
+		s.Reset()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, reconcileStreamTimeout)
+	defer cancel()
+
+	resp := r.respond(ctx, req)
+	if err := json.NewEncoder(s).Encode(resp); err != nil {
+		s.Reset()
+	}
+}
+
+// respond 根据本地数据构造应答
+func (r *Reconciler) respond(ctx context.Context, req *reconcileRequest) *reconcileResponse {
+	resp := &reconcileResponse{}
+
+	r.mu.RLock()
+	adapter, ok := r.adapters[req.Address]
+	r.mu.RUnlock()
+	if !ok {
+		resp.Error = "未知的数据库地址"
+		return resp
+	}
+
+	switch req.Op {
+	case reconcileOpSummary, reconcileOpIDs:
+		if req.BucketSize <= 0 {
+			resp.Error = "分桶宽度必须为正数"
+			return resp
+		}
+		index, err := adapter.eventIndex(ctx, req.BucketSize)
+		if err != nil {
+			resp.Error = err.Error()
+			return resp
+		}
+		if req.Op == reconcileOpSummary {
+			resp.Buckets = summarize(index)
+			return resp
+		}
+		for _, start := range req.Buckets {
+			resp.IDs = append(resp.IDs, index[start]...)
+		}
+	case reconcileOpEvents:
+		if len(req.IDs) > reconcileBatch {
+			resp.Error = fmt.Sprintf("单次最多请求 %d 个事件", reconcileBatch)
+			return resp
+		}
+		if len(req.IDs) == 0 {
+			return resp
+		}
+		events, err := adapter.QueryEvents(ctx, nostr.Filter{IDs: req.IDs})
+		if err != nil {
+			resp.Error = err.Error()
+			return resp
+		}
+		for evt := range events {
+			resp.Events = append(resp.Events, evt)
+		}
+	default:
+		resp.Error = fmt.Sprintf("未知的操作 %q", req.Op)
+	}
+	return resp
+}
+
+// eventIndex 按 created_at 分桶的事件 ID，每个桶内按 ID 排序
+type eventIndex map[int64][]string
+
+func (idx eventIndex) count() int {
+	n := 0
+	for _, ids := range idx {
+		n += len(ids)
+	}
+	return n
+}
+
+// eventIndex 读取数据库中所有事件的 ID 并按 created_at 分桶
+func (a *OrbitDBAdapter) eventIndex(ctx context.Context, bucketSize int64) (eventIndex, error) {
+	index := make(eventIndex)
+	_, err := a.query(ctx, func(doc interface{}) (bool, error) {
+		event, ok := doc.(map[string]interface{})
+		if !ok {
+			return false, nil
+		}
+		id, ok := event["_id"].(string)
+		if !ok {
+			return false, nil
+		}
+		createdAt, _ := event["created_at"].(float64)
+		start := int64(createdAt) / bucketSize * bucketSize
+		index[start] = append(index[start], id)
+		return false, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("读取事件索引失败: %w", err)
+	}
+	for _, ids := range index {
+		sort.Strings(ids)
+	}
+	return index, nil
+}
+
+// summarize 计算每个分桶的摘要，按起点排序
+func summarize(index eventIndex) []BucketSummary {
+	summaries := make([]BucketSummary, 0, len(index))
+	for start, ids := range index {
+		h := sha256.New()
+		for _, id := range ids {
+			h.Write([]byte(id))
+			h.Write([]byte{'\n'})
+		}
+		summaries = append(summaries, BucketSummary{
+			Start: start,
+			Count: len(ids),
+			Hash:  hex.EncodeToString(h.Sum(nil)),
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Start < summaries[j].Start })
+	return summaries
+}
+
+// rootHash 计算全部分桶摘要的哈希
+func rootHash(summaries []BucketSummary) string {
+	sorted := slices.Clone(summaries)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	h := sha256.New()
+	for _, b := range sorted {
+		fmt.Fprintf(h, "%d:%d:%s\n", b.Start, b.Count, b.Hash)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// validEvent 校验事件的 ID 与签名
+func validEvent(evt *nostr.Event) bool {
+	if evt.ID != evt.GetID() {
+		return false
+	}
+	ok, err := evt.CheckSignature()
+	return err == nil && ok
+}
@@ -0,0 +1,108 @@
+package orbitdb_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/nbd-wtf/go-nostr"
+
+	nostrstore "github.com/maoaixiao1314/orbitdb/orbitdb"
+)
+
+func TestReconcile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+	orbitA, nodeA := testNode(ctx, t, mn)
+	orbitB, nodeB := testNode(ctx, t, mn)
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 两个副本不复制日志，事件集合只能通过对账收敛
+	a := openDocsWith(ctx, t, orbitA, "reconcile", false)
+	defer a.Close()
+	b := openDocsWith(ctx, t, orbitB, "reconcile", false)
+	defer b.Close()
+	if a.db.Address().String() != b.db.Address().String() {
+		t.Fatalf("两个副本的地址不同: %s, %s", a.db.Address(), b.db.Address())
+	}
+
+	events := signedEvents(t, 1, 2, 3, 4)
+	reaction := &nostr.Event{CreatedAt: 5, Kind: 7, Tags: nostr.Tags{}, Content: "+"}
+	if err := reaction.Sign(nostr.GeneratePrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+	saveEvents(t, a, events[0], events[1], events[2], reaction)
+	saveEvents(t, b, events[2], events[3])
+
+	ra := nostrstore.NewReconciler(ctx, nodeA.PeerHost, nil)
+	defer ra.Close()
+	ra.Register(a.OrbitDBAdapter)
+	rb := nostrstore.NewReconciler(ctx, nodeB.PeerHost, &nostrstore.ReconcileOptions{
+		Accept: func(evt *nostr.Event) error {
+			if evt.Kind == 7 {
+				return errors.New("不接受 kind 7")
+			}
+			return nil
+		},
+	})
+	defer rb.Close()
+	rb.Register(b.OrbitDBAdapter)
+
+	// B 拉取 A 独有的事件，未通过策略检查的事件不保存
+	report, err := rb.Reconcile(ctx, nodeA.Identity, b.OrbitDBAdapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Converged || report.LocalRoot == report.RemoteRoot {
+		t.Error("事件集合不同的副本被报告为已收敛")
+	}
+	if report.LocalEvents != 2 || report.RemoteEvents != 4 {
+		t.Errorf("本地 %d 个、对端 %d 个事件，应为 2 个和 4 个", report.LocalEvents, report.RemoteEvents)
+	}
+	if report.DivergentBuckets != 1 || report.Missing != 3 || report.Extra != 1 {
+		t.Errorf("不一致的分桶 %d 个、缺失 %d 个、多出 %d 个，应为 1、3、1", report.DivergentBuckets, report.Missing, report.Extra)
+	}
+	if report.Pulled != 2 || report.Rejected != 1 {
+		t.Errorf("拉取 %d 个、拒绝 %d 个事件，应为 2 个和 1 个", report.Pulled, report.Rejected)
+	}
+	got := storedIDs(t, b.OrbitDBAdapter)
+	for _, evt := range events {
+		if !got[evt.ID] {
+			t.Errorf("B 缺少事件 %s", evt.ID)
+		}
+	}
+	if got[reaction.ID] {
+		t.Error("B 保存了未通过策略检查的事件")
+	}
+
+	// A 拉取 B 独有的事件，删除 B 拒绝的事件后两个副本收敛
+	report, err = ra.Reconcile(ctx, nodeB.Identity, a.OrbitDBAdapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Missing != 1 || report.Pulled != 1 || report.Extra != 1 {
+		t.Errorf("A 缺失 %d 个、拉取 %d 个、多出 %d 个事件，应为 1、1、1", report.Missing, report.Pulled, report.Extra)
+	}
+	if err := a.DeleteEvent(ctx, reaction); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = rb.Reconcile(ctx, nodeA.Identity, b.OrbitDBAdapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Converged || report.LocalRoot != report.RemoteRoot || report.Missing != 0 {
+		t.Errorf("事件集合相同的副本未收敛: %+v", report)
+	}
+	if reports := rb.Reports(); len(reports) != 1 || !reports[0].Converged {
+		t.Errorf("Reports 应只有一份已收敛的报告: %+v", reports)
+	}
+}
@@ -0,0 +1,103 @@
+package orbitdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// errRelayClosed 连接被关闭
+var errRelayClosed = errors.New("与中继的连接已关闭")
+
+// relayConn 用于发布事件的中继连接。它自己读取中继发来的消息，
+// 把 OK 应答按类型解析为 nostr.OKEnvelope 交给发布者，而不是从错误信息中猜测中继是否拒绝了事件。
+// 同一时间只能有一个 publish 调用。
+type relayConn struct {
+	conn *nostr.Connection
+	// ctx 在连接断开或关闭时结束，context.Cause 给出原因
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	oks    chan *nostr.OKEnvelope
+	done   chan struct{}
+}
+
+// dialRelay 连接 url 处的中继，直到调用 close 或 ctx 结束
+func dialRelay(ctx context.Context, url string) (*relayConn, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, mirrorPublishTimeout)
+	defer cancel()
+	conn, err := nostr.NewConnection(dialCtx, nostr.NormalizeURL(url), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("无法连接中继 %s: %w", url, err)
+	}
+
+	c := &relayConn{
+		conn: conn,
+		oks:  make(chan *nostr.OKEnvelope, 16),
+		done: make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancelCause(ctx)
+	go c.read()
+	return c, nil
+}
+
+// read 读取中继的消息直到连接断开，只转交 OK 应答
+func (c *relayConn) read() {
+	defer close(c.done)
+	buf := new(bytes.Buffer)
+	for {
+		buf.Reset()
+		if err := c.conn.ReadMessage(c.ctx, buf); err != nil {
+			c.cancel(err)
+			return
+		}
+		ok, isOK := nostr.ParseMessage(buf.Bytes()).(*nostr.OKEnvelope)
+		if !isOK {
+			continue
+		}
+		// 没有人等待的应答（例如超时之后才到达的）在缓冲区满时丢弃
+		select {
+		case c.oks <- ok:
+		default:
+		}
+	}
+}
+
+// connected 连接是否仍然可用
+func (c *relayConn) connected() bool {
+	return c.ctx.Err() == nil
+}
+
+// publish 发送事件并等待中继对它的 OK 应答。中继拒绝事件时返回 OK 为 false 的应答而不是错误；
+// 连接断开或 ctx 结束时返回错误
+func (c *relayConn) publish(ctx context.Context, evt *nostr.Event) (*nostr.OKEnvelope, error) {
+	data, err := nostr.EventEnvelope{Event: *evt}.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("无法编码事件 %s: %w", evt.ID, err)
+	}
+	if err := c.conn.WriteMessage(ctx, data); err != nil {
+		c.cancel(err)
+		return nil, err
+	}
+	for {
+		select {
+		case ok := <-c.oks:
+			if ok.EventID == evt.ID {
+				return ok, nil
+			}
+		case <-ctx.Done():
+			return nil, fmt.Errorf("中继没有确认事件 %s: %w", evt.ID, ctx.Err())
+		case <-c.ctx.Done():
+			return nil, context.Cause(c.ctx)
+		}
+	}
+}
+
+// close 关闭连接并等待读取结束
+func (c *relayConn) close() {
+	c.cancel(errRelayClosed)
+	c.conn.Close()
+	<-c.done
+}
@@ -0,0 +1,509 @@
+package orbitdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"berty.tech/go-orbit-db/baseorbitdb"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores"
+	cid "github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrTrackerClosed 复制跟踪停止后仍在等待同步时返回
+var ErrTrackerClosed = errors.New("复制跟踪已停止")
+
+// replicationEventBuffer 每个订阅者的事件缓冲区大小
+const replicationEventBuffer = 64
+
+// ReplicationEventType 复制进度事件的类型
+type ReplicationEventType string
+
+const (
+	// ReplicationQueued 收到对端的 head，开始复制
+	ReplicationQueued ReplicationEventType = "queued"
+	// ReplicationProgress 复制器取回了一个条目
+	ReplicationProgress ReplicationEventType = "progress"
+	// ReplicationLoaded 一批复制的条目已合并到本地日志
+	ReplicationLoaded ReplicationEventType = "loaded"
+	// ReplicationPeerJoined 数据库主题上出现了新的对端
+	ReplicationPeerJoined ReplicationEventType = "peer_joined"
+	// ReplicationPeerHeads 对端通告了它的 heads
+	ReplicationPeerHeads ReplicationEventType = "peer_heads"
+	// ReplicationSynced 本地已包含所有对端通告的 heads，且复制队列为空
+	ReplicationSynced ReplicationEventType = "synced"
+	// ReplicationUnsynced 出现了本地尚未包含的 heads
+	ReplicationUnsynced ReplicationEventType = "unsynced"
+)
+
+// ReplicationEvent 一条复制进度事件
+type ReplicationEvent struct {
+	Type     ReplicationEventType `json:"type"`
+	Time     time.Time            `json:"time"`
+	Peer     peer.ID              `json:"peer,omitempty"`
+	Hash     cid.Cid              `json:"hash,omitempty"`
+	Entries  int                  `json:"entries,omitempty"`
+	Progress int                  `json:"progress"`
+	Max      int                  `json:"max"`
+	Synced   bool                 `json:"synced"`
+}
+
+// PeerReplication 单个对端的复制状态
+type PeerReplication struct {
+	Peer peer.ID `json:"peer"`
+	// Heads 对端最近一次通告的 heads
+	Heads []cid.Cid `json:"heads"`
+	// Missing 其中本地日志尚未包含的 heads
+	Missing []cid.Cid `json:"missing,omitempty"`
+	// LastSeen 最近一次收到该对端 heads 的时间
+	LastSeen time.Time `json:"last_seen"`
+	// LastSync 最近一次本地包含该对端全部 heads 的时间
+	LastSync time.Time `json:"last_sync,omitempty"`
+	Synced   bool      `json:"synced"`
+}
+
+// ReplicationStatus 数据库的复制状态快照。LogLength 是内存中已加载的日志条目数，
+// 从索引快照启动时只包括快照之后的条目；数据库中的事件数见 OrbitDBAdapter.IndexedEvents
+type ReplicationStatus struct {
+	Address    string            `json:"address"`
+	LocalHeads []cid.Cid         `json:"local_heads"`
+	Queued     int               `json:"queued"`
+	Progress   int               `json:"progress"`
+	Max        int               `json:"max"`
+	Loaded     int               `json:"loaded"`
+	LogLength  int               `json:"log_length"`
+	Peers      []PeerReplication `json:"peers"`
+	Synced     bool              `json:"synced"`
+	LastSync   time.Time         `json:"last_sync,omitempty"`
+	// MessagesIn 和 MessagesOut 是数据库主题上收到和发出的 heads 消息数
+	MessagesIn  int `json:"messages_in"`
+	MessagesOut int `json:"messages_out"`
+}
+
+// peerHeads 记录对端通告的 heads
+type peerHeads struct {
+	heads    []cid.Cid
+	lastSeen time.Time
+	lastSync time.Time
+}
+
+// ReplicationTracker 基于存储的事件总线和数据库的 pubsub 主题跟踪复制状态。
+// 对端的 heads 来自加入时的 heads 交换以及写入时在主题上的广播。
+type ReplicationTracker struct {
+	db     iface.Store
+	has    func(cid.Cid) bool
+	log    *slog.Logger
+	self   peer.ID
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	peers    map[peer.ID]*peerHeads
+	loaded   int
+	synced   bool
+	lastSync time.Time
+	started  time.Time
+	msgsIn   int
+	msgsOut  int
+	// batchStart 当前这批复制开始排队的时间，没有进行中的复制时为零值
+	batchStart time.Time
+	subs       map[chan ReplicationEvent]struct{}
+	closed     bool
+}
+
+// NewReplicationTracker 开始跟踪 db 的复制状态，直到调用 Close。
+// has 报告对端通告的 head 是否已在本地，为 nil 时只检查已加载的日志；
+// 从索引快照启动时应传入 OrbitDBAdapter.HasEntry，快照覆盖的条目没有加载到日志中
+func NewReplicationTracker(ctx context.Context, orbit iface.OrbitDB, db iface.Store, has func(cid.Cid) bool) (*ReplicationTracker, error) {
+	key, err := db.IPFS().Key().Self(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("无法获取本节点 ID: %w", err)
+	}
+
+	storeSub, err := db.EventBus().Subscribe([]interface{}{
+		new(stores.EventReplicate),
+		new(stores.EventReplicateProgress),
+		new(stores.EventReplicated),
+		new(stores.EventNewPeer),
+		new(stores.EventWrite),
+	}, eventbus.Name("orbitdb/replication-tracker"), eventbus.BufSize(128))
+	if err != nil {
+		return nil, fmt.Errorf("无法订阅存储事件: %w", err)
+	}
+
+	headsSub, err := orbit.EventBus().Subscribe(new(baseorbitdb.EventExchangeHeads),
+		eventbus.Name("orbitdb/replication-tracker-heads"), eventbus.BufSize(128))
+	if err != nil {
+		storeSub.Close()
+		return nil, fmt.Errorf("无法订阅 heads 交换事件: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	topicSub, err := db.IPFS().PubSub().Subscribe(ctx, db.Address().String())
+	if err != nil {
+		cancel()
+		storeSub.Close()
+		headsSub.Close()
+		return nil, fmt.Errorf("无法订阅数据库主题: %w", err)
+	}
+
+	t := &ReplicationTracker{
+		db:      db,
+		has:     entryCheck(db, has),
+		log:     componentLogger("replication"),
+		self:    key.ID(),
+		cancel:  cancel,
+		peers:   make(map[peer.ID]*peerHeads),
+		started: time.Now(),
+		subs:    make(map[chan ReplicationEvent]struct{}),
+	}
+	t.synced = t.checkSynced()
+	if t.synced {
+		t.lastSync = time.Now()
+	}
+
+	t.wg.Add(2)
+	go func() {
+		defer t.wg.Done()
+		defer storeSub.Close()
+		defer headsSub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-storeSub.Out():
+				t.handleStoreEvent(e)
+			case e := <-headsSub.Out():
+				evt := e.(baseorbitdb.EventExchangeHeads)
+				if evt.Message != nil && evt.Message.Address == db.Address().String() {
+					t.handleHeads(evt.Peer, evt.Message)
+				}
+			}
+		}
+	}()
+	go func() {
+		defer t.wg.Done()
+		defer topicSub.Close()
+		for {
+			msg, err := topicSub.Next(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					t.log.Warn("failed to read database topic", "db", db.Address().String(), "err", err)
+				}
+				return
+			}
+			if msg.From() == t.self {
+				continue
+			}
+			t.mu.Lock()
+			t.msgsIn++
+			t.mu.Unlock()
+			heads := &iface.MessageExchangeHeads{}
+			if err := json.Unmarshal(msg.Data(), heads); err != nil {
+				continue
+			}
+			t.handleHeads(msg.From(), heads)
+		}
+	}()
+
+	return t, nil
+}
+
+// Subscribe 返回复制进度事件的通道以及取消订阅的函数。
+// 订阅者处理过慢时多余的事件会被丢弃，不会阻塞复制。
+func (t *ReplicationTracker) Subscribe() (<-chan ReplicationEvent, func()) {
+	ch := make(chan ReplicationEvent, replicationEventBuffer)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	t.subs[ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if _, ok := t.subs[ch]; ok {
+				delete(t.subs, ch)
+				close(ch)
+			}
+		})
+	}
+}
+
+// Synced 报告本地是否已包含所有对端通告的 heads 且复制队列为空
+func (t *ReplicationTracker) Synced() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.synced
+}
+
+// WaitSynced 阻塞直到至少一个对端通告的 heads 已全部取回并加载、且复制队列为空，
+// 或 ctx 结束。返回跟踪开始以来加载的条目数；超时时同时返回 ctx.Err()。
+func (t *ReplicationTracker) WaitSynced(ctx context.Context) (int, error) {
+	events, unsubscribe := t.Subscribe()
+	defer unsubscribe()
+
+	for {
+		t.mu.Lock()
+		done := t.peerSynced()
+		loaded := t.loaded
+		t.mu.Unlock()
+		if done {
+			return loaded, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return loaded, ctx.Err()
+		case _, ok := <-events:
+			if !ok {
+				return loaded, ErrTrackerClosed
+			}
+		}
+	}
+}
+
+// peerSynced 报告是否已有对端的 heads 全部在本地日志中且复制已完成。调用方需持有 mu。
+func (t *ReplicationTracker) peerSynced() bool {
+	if !t.synced {
+		return false
+	}
+	for _, p := range t.peers {
+		if !p.lastSync.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// Status 返回当前的复制状态
+func (t *ReplicationTracker) Status() ReplicationStatus {
+	info := t.db.ReplicationStatus()
+	status := ReplicationStatus{
+		Address:   t.db.Address().String(),
+		Queued:    len(t.db.Replicator().GetQueue()),
+		Progress:  info.GetProgress(),
+		Max:       info.GetMax(),
+		LogLength: t.db.OpLog().Len(),
+	}
+	for _, head := range t.db.OpLog().Heads().Slice() {
+		status.LocalHeads = append(status.LocalHeads, head.GetHash())
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status.Loaded = t.loaded
+	status.Synced = t.synced
+	status.LastSync = t.lastSync
+	status.MessagesIn = t.msgsIn
+	status.MessagesOut = t.msgsOut
+	for id, p := range t.peers {
+		pr := PeerReplication{
+			Peer:     id,
+			Heads:    p.heads,
+			Missing:  t.missing(p.heads),
+			LastSeen: p.lastSeen,
+			LastSync: p.lastSync,
+		}
+		pr.Synced = len(pr.Missing) == 0
+		status.Peers = append(status.Peers, pr)
+	}
+	sort.Slice(status.Peers, func(i, j int) bool { return status.Peers[i].Peer < status.Peers[j].Peer })
+	return status
+}
+
+// Lag 返回自上次同步以来的时间，已同步时为 0；从未同步过时从开始跟踪算起
+func (t *ReplicationTracker) Lag() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.synced {
+		return 0
+	}
+	if t.lastSync.IsZero() {
+		return time.Since(t.started)
+	}
+	return time.Since(t.lastSync)
+}
+
+// Close 停止跟踪并关闭所有订阅的通道
+func (t *ReplicationTracker) Close() {
+	t.cancel()
+	t.wg.Wait()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+	for ch := range t.subs {
+		close(ch)
+	}
+	t.subs = nil
+}
+
+// handleStoreEvent 处理存储事件总线上的事件
+func (t *ReplicationTracker) handleStoreEvent(e interface{}) {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch evt := e.(type) {
+	case stores.EventReplicate:
+		if t.batchStart.IsZero() {
+			t.batchStart = now
+		}
+		t.publish(ReplicationEvent{Type: ReplicationQueued, Time: now, Hash: evt.Hash})
+	case stores.EventReplicateProgress:
+		t.publish(ReplicationEvent{
+			Type:     ReplicationProgress,
+			Time:     now,
+			Hash:     evt.Hash,
+			Progress: evt.Progress,
+			Max:      evt.Max,
+		})
+	case stores.EventReplicated:
+		t.loaded += len(evt.Entries)
+		t.traceBatch(evt, now)
+		t.publish(ReplicationEvent{Type: ReplicationLoaded, Time: now, Entries: len(evt.Entries)})
+	case stores.EventNewPeer:
+		t.publish(ReplicationEvent{Type: ReplicationPeerJoined, Time: now, Peer: evt.Peer})
+	case stores.EventWrite:
+		// 每次写入都会在主题上广播新的 heads
+		t.msgsOut++
+		// 本地写入只会前移本地 heads，不影响对端的同步状态，只需重新评估
+	default:
+		return
+	}
+	t.update(now)
+}
+
+// traceBatch 为一批已合并的复制条目记录一个 span，从第一个 head 排队开始到合并结束。调用方需持有 mu。
+func (t *ReplicationTracker) traceBatch(evt stores.EventReplicated, now time.Time) {
+	start := t.batchStart
+	if start.IsZero() {
+		start = now
+	}
+	t.batchStart = time.Time{}
+	if len(t.db.Replicator().GetQueue()) > 0 {
+		// 队列中还有 head，下一批从现在算起
+		t.batchStart = now
+	}
+
+	_, span := tracer.Start(context.Background(), "orbitdb.replication.Load",
+		trace.WithTimestamp(start),
+		trace.WithAttributes(
+			attribute.String("orbitdb.address", t.db.Address().String()),
+			attribute.Int("orbitdb.entries", len(evt.Entries)),
+			attribute.Int("orbitdb.log_length", evt.LogLength),
+		))
+	span.End(trace.WithTimestamp(now))
+}
+
+// handleHeads 记录对端通告的 heads
+func (t *ReplicationTracker) handleHeads(id peer.ID, msg *iface.MessageExchangeHeads) {
+	now := time.Now()
+	heads := make([]cid.Cid, 0, len(msg.Heads))
+	for _, head := range msg.Heads {
+		if head != nil {
+			heads = append(heads, head.GetHash())
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[id]
+	if !ok {
+		p = &peerHeads{}
+		t.peers[id] = p
+	}
+	p.heads = heads
+	p.lastSeen = now
+	t.publish(ReplicationEvent{Type: ReplicationPeerHeads, Time: now, Peer: id, Entries: len(heads)})
+	t.update(now)
+}
+
+// update 重新计算同步状态，状态变化时发布 synced/unsynced 事件。调用方需持有 mu。
+func (t *ReplicationTracker) update(now time.Time) {
+	for _, p := range t.peers {
+		if len(t.missing(p.heads)) == 0 {
+			p.lastSync = now
+		}
+	}
+
+	synced := t.checkSynced()
+	if synced {
+		t.lastSync = now
+	}
+	if synced == t.synced {
+		return
+	}
+	t.synced = synced
+
+	typ := ReplicationUnsynced
+	if synced {
+		typ = ReplicationSynced
+	}
+	info := t.db.ReplicationStatus()
+	t.publish(ReplicationEvent{
+		Type:     typ,
+		Time:     now,
+		Progress: info.GetProgress(),
+		Max:      info.GetMax(),
+	})
+}
+
+// checkSynced 复制队列为空、复制进度完成且所有对端的 heads 都已在本地日志中
+func (t *ReplicationTracker) checkSynced() bool {
+	if len(t.db.Replicator().GetQueue()) > 0 {
+		return false
+	}
+	if info := t.db.ReplicationStatus(); info.GetProgress() < info.GetMax() {
+		return false
+	}
+	for _, p := range t.peers {
+		if len(t.missing(p.heads)) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// missing 返回 heads 中本地尚没有的条目
+func (t *ReplicationTracker) missing(heads []cid.Cid) []cid.Cid {
+	var missing []cid.Cid
+	for _, head := range heads {
+		if !t.has(head) {
+			missing = append(missing, head)
+		}
+	}
+	return missing
+}
+
+// publish 将事件发给所有订阅者，缓冲区已满的订阅者会丢失该事件。调用方需持有 mu。
+func (t *ReplicationTracker) publish(evt ReplicationEvent) {
+	evt.Synced = t.synced
+	for ch := range t.subs {
+		select {
+		case ch <- evt:
+		default:
+		}
+	}
+}
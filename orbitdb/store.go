@@ -182,31 +182,173 @@ package orbitdb
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores"
+	"berty.tech/go-orbit-db/stores/operation"
+	cid "github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
 	"github.com/nbd-wtf/go-nostr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// OrbitDBAdapter 实现 eventstore.Store 接口
+var (
+	// ErrClosed 适配器关闭后继续写入时返回
+	ErrClosed = errors.New("orbitdb 适配器已关闭")
+	// ErrNotFound 删除的事件不在数据库中时返回
+	ErrNotFound = errors.New("数据库中没有该事件")
+)
+
+// subscribeQueueSize Subscribe 返回的通道的缓冲长度
+const subscribeQueueSize = 1000
+
+// OrbitDBAdapter 以 OrbitDB 文档存储实现 Store
 type OrbitDBAdapter struct {
-	db iface.DocumentStore
+	db  iface.DocumentStore
+	log *slog.Logger
+
+	// index 读操作使用的文档索引，由写入、复制和加载事件更新
+	index *docIndex
+	sub   event.Subscription
+	wg    sync.WaitGroup
+	// indexReady 在 Load 加载的日志合并到索引后设置
+	indexReady atomic.Bool
+	// covered 启动时加载的索引快照覆盖的条目，Load 不一定加载它们
+	coveredMu sync.RWMutex
+	covered   map[cid.Cid]struct{}
+
+	// metrics 由 NewMetrics 设置，为 nil 时不记录
+	metrics *Metrics
+
+	// mu 保护 closed；写操作持有读锁，Close 持有写锁以等待进行中的写入结束
+	mu     sync.RWMutex
+	closed bool
+	// done 在 Close 时关闭，结束进行中的订阅
+	done chan struct{}
+	// replaceMu 串行化 ReplaceEvent，避免同一地址的两个事件同时保存
+	replaceMu sync.Mutex
+}
+
+// NewOrbitDBAdapter 创建一个新的 OrbitDB 适配器。
+// 适配器维护自己的文档索引：先合并 db 中已加载的条目，之后随写入、复制和 Load 增量更新，直到 Close。
+func NewOrbitDBAdapter(db iface.DocumentStore) (*OrbitDBAdapter, error) {
+	sub, err := db.EventBus().Subscribe([]interface{}{
+		new(stores.EventWrite),
+		new(stores.EventReplicated),
+		new(stores.EventReady),
+	}, eventbus.Name("orbitdb/adapter-index"), eventbus.BufSize(128))
+	if err != nil {
+		return nil, fmt.Errorf("无法订阅存储事件: %w", err)
+	}
+
+	a := &OrbitDBAdapter{
+		db:    db,
+		log:   componentLogger("adapter"),
+		index: newDocIndex(),
+		sub:   sub,
+		done:  make(chan struct{}),
+	}
+	// 订阅之后再合并，期间写入的条目会合并两次，没有影响
+	if err := a.index.apply(db.OpLog().Values().Slice()...); err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		for e := range sub.Out() {
+			a.handleStoreEvent(e)
+		}
+	}()
+	return a, nil
+}
+
+// handleStoreEvent 将存储事件中的条目合并到索引
+func (a *OrbitDBAdapter) handleStoreEvent(e interface{}) {
+	var err error
+	switch evt := e.(type) {
+	case stores.EventWrite:
+		err = a.index.apply(evt.Entry)
+	case stores.EventReplicated:
+		err = a.index.apply(evt.Entries...)
+	case stores.EventReady:
+		// Load 不逐条发布条目，合并整个已加载的日志
+		if err = a.index.apply(a.db.OpLog().Values().Slice()...); err == nil {
+			a.indexReady.Store(true)
+		}
+	}
+	if err != nil {
+		a.log.Error("failed to update document index", "err", err)
+	}
+}
+
+// IndexReady 报告 Load 加载的日志（以及之前合并的索引快照）是否已合并到索引。
+// 在此之前查询只能看到部分事件
+func (a *OrbitDBAdapter) IndexReady() bool {
+	return a.indexReady.Load()
 }
 
-// NewOrbitDBAdapter 创建一个新的 OrbitDB 适配器
-func NewOrbitDBAdapter(db iface.DocumentStore) *OrbitDBAdapter {
-	return &OrbitDBAdapter{
-		db: db,
+// IndexedEvents 返回索引中未删除的事件数，不受部分加载的日志影响
+func (a *OrbitDBAdapter) IndexedEvents() int {
+	return a.index.count()
+}
+
+// query 与 DocumentStore.Query 相同，但读取适配器的索引
+func (a *OrbitDBAdapter) query(ctx context.Context, filter func(doc interface{}) (bool, error)) (docs []interface{}, err error) {
+	ctx, span := tracer.Start(ctx, "orbitdb.db.Query")
+	values := a.index.values()
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("orbitdb.documents.scanned", len(values)),
+			attribute.Int("orbitdb.documents.matched", len(docs)),
+		)
+		endSpan(span, err)
+	}()
+
+	for _, value := range values {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(value, &doc); err != nil {
+			return nil, fmt.Errorf("无法解析文档: %w", err)
+		}
+		if ok, err := filter(doc); err != nil {
+			return nil, fmt.Errorf("过滤文档失败: %w", err)
+		} else if ok {
+			docs = append(docs, doc)
+		}
 	}
+	return docs, nil
 }
 
 // SaveEvent 保存事件到 OrbitDB
 // 更新签名以匹配 func(ctx context.Context, event *nostr.Event) error
-func (a *OrbitDBAdapter) SaveEvent(ctx context.Context, event *nostr.Event) error {
+func (a *OrbitDBAdapter) SaveEvent(ctx context.Context, event *nostr.Event) (err error) {
 	if event == nil {
+		a.metrics.Reject(RejectInvalid)
 		return fmt.Errorf("事件不能为空")
 	}
+	ctx, span := tracer.Start(ctx, "orbitdb.SaveEvent", trace.WithAttributes(eventAttributes(event)...))
+	defer func() { endSpan(span, err) }()
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		a.metrics.Reject(RejectClosed)
+		return ErrClosed
+	}
 
 	doc := map[string]interface{}{
 		"_id":        event.ID,
@@ -218,8 +360,16 @@ func (a *OrbitDBAdapter) SaveEvent(ctx context.Context, event *nostr.Event) erro
 		"tags":       event.Tags,
 	}
 
-	_, err := a.db.Put(ctx, doc)
-	return err
+	putCtx, putSpan := tracer.Start(ctx, "orbitdb.db.Put")
+	op, err := a.db.Put(putCtx, doc)
+	endSpan(putSpan, err)
+	if err != nil {
+		a.metrics.Reject(RejectError)
+		return err
+	}
+	a.metrics.savedEvent()
+	// 不等写入事件，保存后立即可以查询到
+	return a.index.apply(op.GetEntry())
 }
 
 // QueryEvents 查询匹配过滤器的事件
@@ -298,184 +448,298 @@ func (a *OrbitDBAdapter) SaveEvent(ctx context.Context, event *nostr.Event) erro
 // 	return eventChan, nil
 // }
 
+// QueryEvents 按 created_at 从新到旧发送匹配过滤器的事件，filter.Limit 大于 0 时最多发送这么多个。
+// 扫描索引在返回前完成，失败时返回错误；发送完或 ctx 结束时关闭通道
 func (a *OrbitDBAdapter) QueryEvents(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
-	// 创建事件通道
-	eventChan := make(chan *nostr.Event)
-
-	go func() {
-		defer close(eventChan)
-
-		// 定义查询函数
-		queryFn := func(doc interface{}) (bool, error) {
-			event, ok := doc.(map[string]interface{})
-			if !ok {
-				return false, nil
-			}
-
-			// 实现过滤逻辑
-			if len(filter.IDs) > 0 {
-				id, ok := event["_id"].(string) // 注意这里是 _id 而不是 id
-				if !ok || !contains(filter.IDs, id) {
-					return false, nil
-				}
-			}
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "orbitdb.QueryEvents", trace.WithAttributes(filterAttributes(filter)...))
+	events, err := a.matchingEvents(ctx, filter)
+	if err != nil {
+		endSpan(span, err)
+		return nil, fmt.Errorf("查询失败: %w", err)
+	}
+	sortEvents(events)
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	a.metrics.observeQuery(filterShape(filter), start)
+	return a.sendEvents(ctx, span, events), nil
+}
 
-			if len(filter.Authors) > 0 {
-				pubkey, ok := event["pubkey"].(string)
-				if !ok || !contains(filter.Authors, pubkey) {
-					return false, nil
-				}
-			}
+// QueryFilters 按 NIP-01 REQ 的语义查询多个过滤器：每个过滤器最多取其 limit 个最新的事件，
+// 合并去重后按 created_at 从新到旧发送。与逐个调用 QueryEvents 结果相同，但只扫描一次索引。
+// 没有过滤器时不发送任何事件。扫描索引在返回前完成，失败时返回错误；发送完或 ctx 结束时关闭通道
+func (a *OrbitDBAdapter) QueryFilters(ctx context.Context, filters nostr.Filters) (chan *nostr.Event, error) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "orbitdb.QueryFilters", trace.WithAttributes(attribute.Int("nostr.filters", len(filters))))
+	if len(filters) == 0 {
+		return a.sendEvents(ctx, span, nil), nil
+	}
 
-			if len(filter.Kinds) > 0 {
-				kind, ok := event["kind"].(float64)
-				if !ok || !containsInt(filter.Kinds, int(kind)) {
-					return false, nil
-				}
+	matched := make([][]*nostr.Event, len(filters))
+	_, err := a.query(ctx, func(doc interface{}) (bool, error) {
+		docMap, ok := doc.(map[string]interface{})
+		if !ok {
+			return false, nil
+		}
+		event := docToEvent(docMap)
+		for i, filter := range filters {
+			if filter.Matches(event) {
+				matched[i] = append(matched[i], event)
 			}
-
-			// 可以添加更多过滤条件...
-
-			return true, nil
 		}
+		return false, nil
+	})
+	if err != nil {
+		endSpan(span, err)
+		return nil, fmt.Errorf("查询失败: %w", err)
+	}
+	events := mergeFilterResults(filters, matched)
+	a.metrics.observeQuery(filtersShape(filters), start)
+	return a.sendEvents(ctx, span, events), nil
+}
 
-		// 执行查询
-		docs, _ := a.db.Query(ctx, queryFn)
-		for _, doc := range docs {
-			// 检查上下文是否已取消
+// sendEvents 在新的 goroutine 中依次发送 events，发送完或 ctx 结束时关闭通道并结束 span
+func (a *OrbitDBAdapter) sendEvents(ctx context.Context, span trace.Span, events []*nostr.Event) chan *nostr.Event {
+	eventChan := make(chan *nostr.Event)
+	go func() {
+		sent := 0
+		defer close(eventChan)
+		defer func() {
+			span.SetAttributes(attribute.Int("nostr.events", sent))
+			endSpan(span, ctx.Err())
+		}()
+		for _, event := range events {
 			select {
 			case <-ctx.Done():
 				return
-			default:
-				// 继续处理
+			case eventChan <- event:
+				sent++
 			}
+		}
+	}()
+	return eventChan
+}
 
-			// 直接构建事件对象，而不是通过JSON序列化和反序列化
-			docMap, ok := doc.(map[string]interface{})
-			if !ok {
-				log.Printf("无效的文档格式")
-				continue
-			}
+// GetEvent 从适配器的索引读取 ID 为 id 的事件，不存在时返回 nil
+func (a *OrbitDBAdapter) GetEvent(ctx context.Context, id string) (*nostr.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	value := a.index.get(id)
+	if value == nil {
+		return nil, nil
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(value, &doc); err != nil {
+		return nil, fmt.Errorf("无法解析文档 %s: %w", id, err)
+	}
+	return docToEvent(doc), nil
+}
 
-			event := &nostr.Event{}
+// DeleteEvent 从数据库中删除事件
+// 更新签名以匹配 func(ctx context.Context, event *nostr.Event) error
+func (a *OrbitDBAdapter) DeleteEvent(ctx context.Context, event *nostr.Event) (err error) {
+	if event == nil {
+		return fmt.Errorf("事件不能为空")
+	}
+	ctx, span := tracer.Start(ctx, "orbitdb.DeleteEvent", trace.WithAttributes(attribute.String("nostr.event.id", event.ID)))
+	defer func() { endSpan(span, err) }()
 
-			// 设置基本字段
-			if id, ok := docMap["_id"].(string); ok {
-				event.ID = id
-			}
-			if pubkey, ok := docMap["pubkey"].(string); ok {
-				event.PubKey = pubkey
-			}
-			if createdAt, ok := docMap["created_at"].(float64); ok {
-				event.CreatedAt = nostr.Timestamp(createdAt)
-			}
-			if kind, ok := docMap["kind"].(float64); ok {
-				event.Kind = int(kind)
-			}
-			if content, ok := docMap["content"].(string); ok {
-				event.Content = content
-			}
-			if sig, ok := docMap["sig"].(string); ok {
-				event.Sig = sig
-			}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return ErrClosed
+	}
 
-			// 处理标签
-			if tagsData, ok := docMap["tags"].([]interface{}); ok {
-				for _, tagData := range tagsData {
-					if tagArray, ok := tagData.([]interface{}); ok {
-						var tag nostr.Tag
-						for _, item := range tagArray {
-							if str, ok := item.(string); ok {
-								tag = append(tag, str)
-							}
-						}
-						event.Tags = append(event.Tags, tag)
-					}
-				}
-			}
+	// 日志可能只加载了一部分，以适配器的索引而不是 DocumentStore.Delete 判断事件是否存在
+	if a.index.get(event.ID) == nil {
+		return fmt.Errorf("%w: %s", ErrNotFound, event.ID)
+	}
+	delCtx, delSpan := tracer.Start(ctx, "orbitdb.db.Delete")
+	e, err := a.db.AddOperation(delCtx, operation.NewOperation(&event.ID, "DEL", nil), nil)
+	endSpan(delSpan, err)
+	if err != nil {
+		return fmt.Errorf("删除事件 %s 失败: %w", event.ID, err)
+	}
+	return a.index.apply(e)
+}
+
+// Close 停止接受新的写入，等待进行中的写入完成，并停止更新索引
+// 底层的 DocumentStore 由调用方负责关闭
+func (a *OrbitDBAdapter) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	a.closed = true
+	close(a.done)
+	a.sub.Close()
+	a.wg.Wait()
+}
+
+// Subscribe 返回调用之后写入或复制到数据库、且匹配任一过滤器的事件，没有过滤器时匹配全部事件。
+// ctx 结束、适配器关闭，或者读取过慢使通道的缓冲已满时关闭通道
+func (a *OrbitDBAdapter) Subscribe(ctx context.Context, filters nostr.Filters) (chan *nostr.Event, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return nil, ErrClosed
+	}
 
-			// 发送事件到通道
+	sub, err := a.db.EventBus().Subscribe([]interface{}{
+		new(stores.EventWrite),
+		new(stores.EventReplicated),
+	}, eventbus.Name("orbitdb/subscribe"), eventbus.BufSize(128))
+	if err != nil {
+		return nil, fmt.Errorf("无法订阅存储事件: %w", err)
+	}
+
+	// 事件总线不能被读取慢的订阅者阻塞，缓冲满时结束订阅而不是等待
+	events := make(chan *nostr.Event, subscribeQueueSize)
+	go func() {
+		defer close(events)
+		defer sub.Close()
+		for {
 			select {
 			case <-ctx.Done():
 				return
-			case eventChan <- event:
-				// 事件已发送
+			case <-a.done:
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				for _, evt := range storeEvents(e) {
+					if len(filters) > 0 && !filters.Match(evt) {
+						continue
+					}
+					if !validEvent(evt) {
+						continue
+					}
+					select {
+					case events <- evt:
+					default:
+						a.log.Warn("dropping slow subscriber", "queue_size", subscribeQueueSize)
+						return
+					}
+				}
 			}
 		}
 	}()
+	return events, nil
+}
 
-	return eventChan, nil
+// Closed 报告适配器是否已关闭
+func (a *OrbitDBAdapter) Closed() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.closed
 }
 
-// DeleteEvent 从数据库中删除事件
-// 更新签名以匹配 func(ctx context.Context, event *nostr.Event) error
-func (a *OrbitDBAdapter) DeleteEvent(ctx context.Context, event *nostr.Event) error {
-	if event == nil {
-		return fmt.Errorf("事件不能为空")
-	}
+// CountEvents 返回匹配过滤器的事件数，不受 filter.Limit 限制
+func (a *OrbitDBAdapter) CountEvents(ctx context.Context, filter nostr.Filter) (count int64, err error) {
+	defer a.metrics.observeQuery(filterShape(filter), time.Now())
+	ctx, span := tracer.Start(ctx, "orbitdb.CountEvents", trace.WithAttributes(filterAttributes(filter)...))
+	defer func() {
+		span.SetAttributes(attribute.Int64("nostr.events", count))
+		endSpan(span, err)
+	}()
 
-	_, err := a.db.Delete(ctx, event.ID)
-	return err
+	events, err := a.matchingEvents(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(events)), nil
 }
 
-// CountEvents 实现计数方法以匹配 Counter 接口
-func (a *OrbitDBAdapter) CountEvents(ctx context.Context, filter nostr.Filter) (int, error) {
-	count := 0
-
-	queryFn := func(doc interface{}) (bool, error) {
-		event, ok := doc.(map[string]interface{})
+// matchingEvents 返回索引中匹配 filter 的全部事件，不受 limit 限制，也不排序
+func (a *OrbitDBAdapter) matchingEvents(ctx context.Context, filter nostr.Filter) ([]*nostr.Event, error) {
+	var events []*nostr.Event
+	_, err := a.query(ctx, func(doc interface{}) (bool, error) {
+		docMap, ok := doc.(map[string]interface{})
 		if !ok {
 			return false, nil
 		}
-
-		// 实现与 QueryEvents 相同的过滤逻辑
-		if len(filter.IDs) > 0 {
-			id, ok := event["_id"].(string)
-			if !ok || !contains(filter.IDs, id) {
-				return false, nil
-			}
+		event := docToEvent(docMap)
+		if filter.Matches(event) {
+			events = append(events, event)
 		}
+		return false, nil
+	})
+	return events, err
+}
 
-		if len(filter.Authors) > 0 {
-			pubkey, ok := event["pubkey"].(string)
-			if !ok || !contains(filter.Authors, pubkey) {
-				return false, nil
-			}
+// sortEvents 将事件按 created_at 从新到旧排序，时间相同时按 ID 排序
+func sortEvents(events []*nostr.Event) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].CreatedAt != events[j].CreatedAt {
+			return events[i].CreatedAt > events[j].CreatedAt
 		}
+		return events[i].ID < events[j].ID
+	})
+}
 
-		if len(filter.Kinds) > 0 {
-			kind, ok := event["kind"].(float64)
-			if !ok || !containsInt(filter.Kinds, int(kind)) {
-				return false, nil
+// mergeFilterResults 合并各过滤器匹配的事件：matched[i] 是匹配 filters[i] 的全部事件，
+// 先按 filters[i].Limit 保留最新的事件，再去重并按 created_at 从新到旧排序
+func mergeFilterResults(filters nostr.Filters, matched [][]*nostr.Event) []*nostr.Event {
+	seen := make(map[string]bool)
+	var events []*nostr.Event
+	for i, filter := range filters {
+		results := matched[i]
+		if filter.Limit > 0 && len(results) > filter.Limit {
+			sortEvents(results)
+			results = results[:filter.Limit]
+		}
+		for _, event := range results {
+			if !seen[event.ID] {
+				seen[event.ID] = true
+				events = append(events, event)
 			}
 		}
-
-		count++
-		return true, nil
 	}
-
-	// 执行查询计数
-	a.db.Query(ctx, queryFn)
-
-	return count, nil
+	sortEvents(events)
+	return events
 }
 
-// 辅助函数：检查切片中是否包含某个字符串
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
+// docToEvent 将数据库中的文档转换为事件
+func docToEvent(docMap map[string]interface{}) *nostr.Event {
+	event := &nostr.Event{}
+
+	// 设置基本字段
+	if id, ok := docMap["_id"].(string); ok {
+		event.ID = id
+	}
+	if pubkey, ok := docMap["pubkey"].(string); ok {
+		event.PubKey = pubkey
+	}
+	if createdAt, ok := docMap["created_at"].(float64); ok {
+		event.CreatedAt = nostr.Timestamp(createdAt)
+	}
+	if kind, ok := docMap["kind"].(float64); ok {
+		event.Kind = int(kind)
+	}
+	if content, ok := docMap["content"].(string); ok {
+		event.Content = content
+	}
+	if sig, ok := docMap["sig"].(string); ok {
+		event.Sig = sig
 	}
-	return false
-}
 
-// 辅助函数：检查切片中是否包含某个整数
-func containsInt(slice []int, item int) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
+	// 处理标签
+	if tagsData, ok := docMap["tags"].([]interface{}); ok {
+		for _, tagData := range tagsData {
+			if tagArray, ok := tagData.([]interface{}); ok {
+				var tag nostr.Tag
+				for _, item := range tagArray {
+					if str, ok := item.(string); ok {
+						tag = append(tag, str)
+					}
+				}
+				event.Tags = append(event.Tags, tag)
+			}
 		}
 	}
-	return false
+	return event
 }
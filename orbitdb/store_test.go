@@ -0,0 +1,239 @@
+package orbitdb_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	orbitdb "berty.tech/go-orbit-db"
+	"berty.tech/go-orbit-db/iface"
+	ds "github.com/ipfs/go-datastore"
+	dsync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/core/coreapi"
+	mock "github.com/ipfs/kubo/core/mock"
+	"github.com/ipfs/kubo/repo"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/nbd-wtf/go-nostr"
+
+	nostrstore "github.com/maoaixiao1314/orbitdb/orbitdb"
+	"github.com/maoaixiao1314/orbitdb/orbitdb/storetest"
+)
+
+// adapterStore 关闭适配器时一并关闭它的数据库
+type adapterStore struct {
+	*nostrstore.OrbitDBAdapter
+	db iface.DocumentStore
+}
+
+func (s adapterStore) Close() {
+	s.OrbitDBAdapter.Close()
+	s.db.Close()
+}
+
+func TestOrbitDBAdapter(t *testing.T) {
+	// 并行的检查在本函数返回之后才执行，ctx 要保持到它们结束
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	orbit := testOrbitDB(ctx, t)
+	var n atomic.Int32
+	storetest.Run(t, func(t *testing.T) nostrstore.Store {
+		db, err := orbit.Docs(ctx, fmt.Sprintf("storetest-%d", n.Add(1)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adapter, err := nostrstore.NewOrbitDBAdapter(db)
+		if err != nil {
+			db.Close()
+			t.Fatal(err)
+		}
+		return adapterStore{OrbitDBAdapter: adapter, db: db}
+	})
+}
+
+// signedBy 返回由 sk 签名、在 ts 创建的 kind 类型事件
+func signedBy(t *testing.T, sk string, ts nostr.Timestamp, kind int) *nostr.Event {
+	t.Helper()
+	evt := &nostr.Event{CreatedAt: ts, Kind: kind, Tags: nostr.Tags{}, Content: fmt.Sprint("kind ", kind, " at ", ts)}
+	if err := evt.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	return evt
+}
+
+func TestQueryFilters(t *testing.T) {
+	adapter := testAdapter(t)
+	alice, bob := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	aliceKey, _ := nostr.GetPublicKey(alice)
+	bobKey, _ := nostr.GetPublicKey(bob)
+
+	aProfile := signedBy(t, alice, 1000, 0)
+	aNote1 := signedBy(t, alice, 1001, 1)
+	bNote1 := signedBy(t, bob, 1002, 1)
+	aNote2 := signedBy(t, alice, 1003, 1)
+	bNote2 := signedBy(t, bob, 1004, 1)
+	aReaction := signedBy(t, alice, 1004, 7)
+	aNote3 := signedBy(t, alice, 1005, 1)
+	bProfile := signedBy(t, bob, 1006, 0)
+	for _, evt := range []*nostr.Event{aNote2, bProfile, aNote1, aReaction, bNote2, aProfile, aNote3, bNote1} {
+		if err := adapter.SaveEvent(context.Background(), evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 同一秒创建的事件按 ID 排序
+	tied := []*nostr.Event{bNote2, aReaction}
+	if aReaction.ID < bNote2.ID {
+		tied = []*nostr.Event{aReaction, bNote2}
+	}
+
+	cases := []struct {
+		name    string
+		filters nostr.Filters
+		want    []*nostr.Event
+	}{
+		{
+			name:    "overlapping filters return each event once",
+			filters: nostr.Filters{{Kinds: []int{1}}, {Authors: []string{aliceKey}}},
+			want:    []*nostr.Event{aNote3, tied[0], tied[1], aNote2, bNote1, aNote1, aProfile},
+		},
+		{
+			name:    "identical filters",
+			filters: nostr.Filters{{Kinds: []int{0}}, {Kinds: []int{0}}},
+			want:    []*nostr.Event{bProfile, aProfile},
+		},
+		{
+			name:    "limit applies to each filter",
+			filters: nostr.Filters{{Kinds: []int{1}, Limit: 2}, {Kinds: []int{0}, Limit: 1}},
+			want:    []*nostr.Event{bProfile, aNote3, bNote2},
+		},
+		{
+			name:    "limit of one filter keeps events matched by another",
+			filters: nostr.Filters{{Kinds: []int{1}, Limit: 1}, {Kinds: []int{1}, Authors: []string{bobKey}}},
+			want:    []*nostr.Event{aNote3, bNote2, bNote1},
+		},
+		{
+			name:    "merged result is not capped by any limit",
+			filters: nostr.Filters{{Kinds: []int{0}, Limit: 2}, {Kinds: []int{7}, Limit: 1}},
+			want:    []*nostr.Event{bProfile, aReaction, aProfile},
+		},
+		{
+			name:    "newest first across filters",
+			filters: nostr.Filters{{Authors: []string{bobKey}}, {Kinds: []int{1}, Authors: []string{aliceKey}}},
+			want:    []*nostr.Event{bProfile, aNote3, bNote2, aNote2, bNote1, aNote1},
+		},
+		{
+			name:    "same second across filters",
+			filters: nostr.Filters{{IDs: []string{tied[1].ID}}, {IDs: []string{tied[0].ID}}},
+			want:    tied,
+		},
+		{
+			name:    "filter without matches",
+			filters: nostr.Filters{{Kinds: []int{30023}}, {IDs: []string{aNote1.ID}}},
+			want:    []*nostr.Event{aNote1},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ch, err := adapter.QueryFilters(context.Background(), c.filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want []string
+			for evt := range ch {
+				got = append(got, evt.ID)
+			}
+			for _, evt := range c.want {
+				want = append(want, evt.ID)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("QueryFilters(%s) 返回 %v，应为 %v", c.filters, got, want)
+			}
+		})
+	}
+}
+
+// testAdapter 返回一个使用新数据库的适配器，测试结束时关闭
+func testAdapter(t *testing.T) *nostrstore.OrbitDBAdapter {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	db, err := testOrbitDB(ctx, t).Docs(ctx, "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter, err := nostrstore.NewOrbitDBAdapter(db)
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(adapterStore{OrbitDBAdapter: adapter, db: db}.Close)
+	return adapter
+}
+
+// testOrbitDB 在新的 mocknet 上启动一个进程内的 IPFS 节点，返回使用临时目录的 OrbitDB 实例
+func testOrbitDB(ctx context.Context, t *testing.T) iface.OrbitDB {
+	t.Helper()
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+	orbit, _ := testNode(ctx, t, mn)
+	return orbit
+}
+
+// testNode 在 mn 上启动一个进程内的 IPFS 节点，返回使用临时目录的 OrbitDB 实例和该节点
+func testNode(ctx context.Context, t *testing.T, mn mocknet.Mocknet) (iface.OrbitDB, *core.IpfsNode) {
+	t.Helper()
+
+	priv, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config.Config
+	cfg.Identity.PeerID = pid.String()
+	cfg.Identity.PrivKey = base64.StdEncoding.EncodeToString(key)
+	cfg.Pubsub.Enabled = config.True
+	cfg.Bootstrap = []string{}
+	cfg.Addresses.Swarm = []string{"/ip4/127.0.0.1/tcp/0"}
+	cfg.Swarm.ResourceMgr.Enabled = config.False
+
+	node, err := core.NewNode(ctx, &core.BuildCfg{
+		Online:    true,
+		Repo:      &repo.Mock{D: dsync.MutexWrap(ds.NewMapDatastore()), C: cfg},
+		Host:      mock.MockHostOption(mn),
+		ExtraOpts: map[string]bool{"pubsub": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Close() })
+
+	api, err := coreapi.NewCoreAPI(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	orbit, err := orbitdb.NewOrbitDB(ctx, api, &orbitdb.NewOrbitDBOptions{Directory: &dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { orbit.Close() })
+	return orbit, node
+}
@@ -0,0 +1,573 @@
+// Package storetest 检查 orbitdb.Store 的实现是否符合接口约定。
+//
+// 每个实现都应当通过 Run，例如在测试中：
+//
+//	func TestMemoryStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) orbitdb.Store {
+//			return orbitdb.NewMemoryStore()
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/maoaixiao1314/orbitdb/orbitdb"
+)
+
+// timeout 单项检查的最长时间，也是等待订阅收到事件的时间
+const timeout = 10 * time.Second
+
+// check 一项检查，store 是由 newStore 创建的空存储
+type check struct {
+	name string
+	run  func(ctx context.Context, store orbitdb.Store, f *fixture) error
+}
+
+var checks = []check{
+	{"保存和读取", checkSaveQuery},
+	{"重复保存", checkDuplicate},
+	{"过滤器", checkFilters},
+	{"排序和数量限制", checkOrderLimit},
+	{"计数", checkCount},
+	{"删除", checkDelete},
+	{"订阅", checkSubscribe},
+	{"多过滤器查询", checkQueryFilters},
+	{"替换", checkReplace},
+	{"关闭", checkClose},
+}
+
+// Run 将每项检查作为 t 的一个并行子测试执行，子测试的名字是检查的名字，
+// 因此可以用 -run 单独执行或跳过某项检查。每项检查使用 newStore 新建的空存储，
+// 结束后将其关闭；newStore 会被并发调用，创建失败时应让传入的 t 失败。
+// 存储没有实现某项检查针对的可选接口时，该检查被跳过
+func Run(t *testing.T, newStore func(t *testing.T) orbitdb.Store) {
+	for _, c := range checks {
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			runCheck(t, c, newStore)
+		})
+	}
+}
+
+// errSkip 存储没有实现检查针对的可选接口
+var errSkip = errors.New("存储没有实现")
+
+func runCheck(t *testing.T, c check, newStore func(t *testing.T) orbitdb.Store) {
+	store := newStore(t)
+	defer store.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	f, err := newFixture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.run(ctx, store, f); errors.Is(err, errSkip) {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fixture 两个作者签名的测试事件
+type fixture struct {
+	alice, bob string
+	// base 测试事件的起始 created_at
+	base nostr.Timestamp
+}
+
+func newFixture() (*fixture, error) {
+	return &fixture{
+		alice: nostr.GeneratePrivateKey(),
+		bob:   nostr.GeneratePrivateKey(),
+		base:  nostr.Timestamp(time.Now().Add(-time.Hour).Unix()),
+	}, nil
+}
+
+// event 返回由 sk 签名、created_at 为 base+offset 的事件
+func (f *fixture) event(sk string, offset int, kind int, tags nostr.Tags, content string) (*nostr.Event, error) {
+	evt := &nostr.Event{
+		CreatedAt: f.base + nostr.Timestamp(offset),
+		Kind:      kind,
+		Tags:      tags,
+		Content:   content,
+	}
+	if err := evt.Sign(sk); err != nil {
+		return nil, fmt.Errorf("签名测试事件失败: %w", err)
+	}
+	return evt, nil
+}
+
+// save 保存 events，任一失败时返回错误
+func save(ctx context.Context, store orbitdb.Store, events ...*nostr.Event) error {
+	for _, evt := range events {
+		if err := store.SaveEvent(ctx, evt); err != nil {
+			return fmt.Errorf("SaveEvent(%s) 失败: %w", evt.ID, err)
+		}
+	}
+	return nil
+}
+
+// query 读取 QueryEvents 返回的全部事件
+func query(ctx context.Context, store orbitdb.Store, filter nostr.Filter) ([]*nostr.Event, error) {
+	ch, err := store.QueryEvents(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("QueryEvents(%s) 失败: %w", filter, err)
+	}
+	var events []*nostr.Event
+	for evt := range ch {
+		events = append(events, evt)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("QueryEvents(%s) 没有结束: %w", filter, err)
+	}
+	return events, nil
+}
+
+// ids 返回事件的 ID 列表
+func ids(events []*nostr.Event) []string {
+	out := make([]string, 0, len(events))
+	for _, evt := range events {
+		out = append(out, evt.ID)
+	}
+	return out
+}
+
+// expectIDs 检查 filter 按顺序返回 want 中的事件
+func expectIDs(ctx context.Context, store orbitdb.Store, filter nostr.Filter, want ...*nostr.Event) error {
+	got, err := query(ctx, store, filter)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(ids(got), ids(want)) {
+		return fmt.Errorf("QueryEvents(%s) 返回 %v，应为 %v", filter, ids(got), ids(want))
+	}
+	return nil
+}
+
+// sameEvent 检查 got 的各字段与 want 相同
+func sameEvent(got, want *nostr.Event) error {
+	if got.ID != want.ID || got.PubKey != want.PubKey || got.CreatedAt != want.CreatedAt ||
+		got.Kind != want.Kind || got.Content != want.Content || got.Sig != want.Sig {
+		return fmt.Errorf("读回的事件 %+v 与保存的 %+v 不同", got, want)
+	}
+	if len(got.Tags) != len(want.Tags) {
+		return fmt.Errorf("事件 %s 的标签 %v 与保存的 %v 不同", want.ID, got.Tags, want.Tags)
+	}
+	for i := range want.Tags {
+		if !reflect.DeepEqual([]string(got.Tags[i]), []string(want.Tags[i])) {
+			return fmt.Errorf("事件 %s 的标签 %v 与保存的 %v 不同", want.ID, got.Tags, want.Tags)
+		}
+	}
+	return nil
+}
+
+func checkSaveQuery(ctx context.Context, store orbitdb.Store, f *fixture) error {
+	evt, err := f.event(f.alice, 0, 1, nostr.Tags{{"t", "storetest"}, {"p", "00", "wss://relay.example"}}, "hello")
+	if err != nil {
+		return err
+	}
+	if err := save(ctx, store, evt); err != nil {
+		return err
+	}
+	got, err := query(ctx, store, nostr.Filter{IDs: []string{evt.ID}})
+	if err != nil {
+		return err
+	}
+	if len(got) != 1 {
+		return fmt.Errorf("按 ID 查询返回 %d 个事件，应为 1 个", len(got))
+	}
+	if err := sameEvent(got[0], evt); err != nil {
+		return err
+	}
+
+	// 修改返回的事件不应影响存储
+	got[0].Content = "changed"
+	again, err := query(ctx, store, nostr.Filter{IDs: []string{evt.ID}})
+	if err != nil {
+		return err
+	}
+	if len(again) != 1 || again[0].Content != evt.Content {
+		return errors.New("修改查询返回的事件改变了存储的事件")
+	}
+
+	if err := store.SaveEvent(ctx, nil); err == nil {
+		return errors.New("SaveEvent(nil) 没有返回错误")
+	}
+	return nil
+}
+
+func checkDuplicate(ctx context.Context, store orbitdb.Store, f *fixture) error {
+	evt, err := f.event(f.alice, 0, 1, nil, "twice")
+	if err != nil {
+		return err
+	}
+	if err := save(ctx, store, evt, evt); err != nil {
+		return err
+	}
+	return expectIDs(ctx, store, nostr.Filter{}, evt)
+}
+
+func checkFilters(ctx context.Context, store orbitdb.Store, f *fixture) error {
+	a1, err := f.event(f.alice, 10, 1, nostr.Tags{{"t", "go"}}, "a1")
+	if err != nil {
+		return err
+	}
+	a2, err := f.event(f.alice, 20, 7, nostr.Tags{{"e", "x"}}, "a2")
+	if err != nil {
+		return err
+	}
+	b1, err := f.event(f.bob, 30, 1, nostr.Tags{{"t", "nostr"}}, "b1")
+	if err != nil {
+		return err
+	}
+	if err := save(ctx, store, a1, a2, b1); err != nil {
+		return err
+	}
+
+	since, until := f.base+20, f.base+20
+	cases := []struct {
+		filter nostr.Filter
+		want   []*nostr.Event
+	}{
+		{nostr.Filter{}, []*nostr.Event{b1, a2, a1}},
+		{nostr.Filter{IDs: []string{a2.ID, b1.ID}}, []*nostr.Event{b1, a2}},
+		{nostr.Filter{Authors: []string{a1.PubKey}}, []*nostr.Event{a2, a1}},
+		{nostr.Filter{Kinds: []int{1}}, []*nostr.Event{b1, a1}},
+		{nostr.Filter{Kinds: []int{1}, Authors: []string{b1.PubKey}}, []*nostr.Event{b1}},
+		{nostr.Filter{Tags: nostr.TagMap{"t": {"go", "rust"}}}, []*nostr.Event{a1}},
+		{nostr.Filter{Tags: nostr.TagMap{"e": {"x"}}}, []*nostr.Event{a2}},
+		{nostr.Filter{Since: &since}, []*nostr.Event{b1, a2}},
+		{nostr.Filter{Until: &until}, []*nostr.Event{a2, a1}},
+		{nostr.Filter{Since: &since, Until: &until}, []*nostr.Event{a2}},
+		{nostr.Filter{Kinds: []int{30023}}, nil},
+	}
+	for _, c := range cases {
+		if err := expectIDs(ctx, store, c.filter, c.want...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkOrderLimit(ctx context.Context, store orbitdb.Store, f *fixture) error {
+	var events []*nostr.Event
+	for i := 0; i < 5; i++ {
+		evt, err := f.event(f.alice, i, 1, nil, fmt.Sprintf("event %d", i))
+		if err != nil {
+			return err
+		}
+		events = append(events, evt)
+	}
+	// 保存顺序不影响返回顺序
+	if err := save(ctx, store, events[2], events[0], events[4], events[1], events[3]); err != nil {
+		return err
+	}
+	if err := expectIDs(ctx, store, nostr.Filter{}, events[4], events[3], events[2], events[1], events[0]); err != nil {
+		return err
+	}
+	// limit 保留最新的事件
+	return expectIDs(ctx, store, nostr.Filter{Limit: 2}, events[4], events[3])
+}
+
+func checkCount(ctx context.Context, store orbitdb.Store, f *fixture) error {
+	for i := 0; i < 3; i++ {
+		evt, err := f.event(f.alice, i, 1, nil, fmt.Sprintf("count %d", i))
+		if err != nil {
+			return err
+		}
+		if err := save(ctx, store, evt); err != nil {
+			return err
+		}
+	}
+	other, err := f.event(f.bob, 0, 7, nil, "other")
+	if err != nil {
+		return err
+	}
+	if err := save(ctx, store, other); err != nil {
+		return err
+	}
+
+	cases := []struct {
+		filter nostr.Filter
+		want   int64
+	}{
+		{nostr.Filter{}, 4},
+		{nostr.Filter{Kinds: []int{1}}, 3},
+		{nostr.Filter{Kinds: []int{1}, Limit: 1}, 3},
+		{nostr.Filter{Authors: []string{other.PubKey}}, 1},
+		{nostr.Filter{Kinds: []int{30023}}, 0},
+	}
+	for _, c := range cases {
+		got, err := store.CountEvents(ctx, c.filter)
+		if err != nil {
+			return fmt.Errorf("CountEvents(%s) 失败: %w", c.filter, err)
+		}
+		if got != c.want {
+			return fmt.Errorf("CountEvents(%s) 返回 %d，应为 %d", c.filter, got, c.want)
+		}
+	}
+	return nil
+}
+
+func checkDelete(ctx context.Context, store orbitdb.Store, f *fixture) error {
+	keep, err := f.event(f.alice, 0, 1, nil, "keep")
+	if err != nil {
+		return err
+	}
+	gone, err := f.event(f.alice, 1, 1, nil, "gone")
+	if err != nil {
+		return err
+	}
+	if err := save(ctx, store, keep, gone); err != nil {
+		return err
+	}
+	if err := store.DeleteEvent(ctx, gone); err != nil {
+		return fmt.Errorf("DeleteEvent(%s) 失败: %w", gone.ID, err)
+	}
+	if err := expectIDs(ctx, store, nostr.Filter{}, keep); err != nil {
+		return err
+	}
+	if err := expectIDs(ctx, store, nostr.Filter{IDs: []string{gone.ID}}); err != nil {
+		return err
+	}
+	if err := store.DeleteEvent(ctx, gone); !errors.Is(err, orbitdb.ErrNotFound) {
+		return fmt.Errorf("删除不存在的事件返回 %v，应为 ErrNotFound", err)
+	}
+	return nil
+}
+
+func checkSubscribe(ctx context.Context, store orbitdb.Store, f *fixture) error {
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := store.Subscribe(subCtx, nostr.Filters{{Kinds: []int{1}}})
+	if err != nil {
+		return fmt.Errorf("Subscribe 失败: %w", err)
+	}
+
+	skipped, err := f.event(f.alice, 0, 7, nil, "not matching")
+	if err != nil {
+		return err
+	}
+	matched, err := f.event(f.alice, 1, 1, nil, "matching")
+	if err != nil {
+		return err
+	}
+	if err := save(ctx, store, skipped, matched); err != nil {
+		return err
+	}
+
+	select {
+	case evt, ok := <-events:
+		if !ok {
+			return errors.New("订阅在收到事件前结束")
+		}
+		if evt.ID != matched.ID {
+			return fmt.Errorf("订阅收到 %s，应为匹配过滤器的 %s", evt.ID, matched.ID)
+		}
+		if err := sameEvent(evt, matched); err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return errors.New("订阅没有收到保存的事件")
+	}
+
+	// ctx 结束后关闭通道
+	cancel()
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return nil
+			}
+		case <-ctx.Done():
+			return errors.New("取消 ctx 后订阅的通道没有关闭")
+		}
+	}
+}
+
+// checkQueryFilters 检查 FiltersQuerier.QueryFilters，存储没有实现 FiltersQuerier 时跳过
+func checkQueryFilters(ctx context.Context, store orbitdb.Store, f *fixture) error {
+	fq, ok := store.(orbitdb.FiltersQuerier)
+	if !ok {
+		return fmt.Errorf("%w orbitdb.FiltersQuerier", errSkip)
+	}
+	var notes []*nostr.Event
+	for i := 0; i < 4; i++ {
+		evt, err := f.event(f.alice, i, 1, nil, fmt.Sprintf("note %d", i))
+		if err != nil {
+			return err
+		}
+		notes = append(notes, evt)
+	}
+	profile, err := f.event(f.bob, 10, 0, nil, "profile")
+	if err != nil {
+		return err
+	}
+	if err := save(ctx, store, append(notes, profile)...); err != nil {
+		return err
+	}
+
+	expect := func(filters nostr.Filters, want ...*nostr.Event) error {
+		ch, err := fq.QueryFilters(ctx, filters)
+		if err != nil {
+			return fmt.Errorf("QueryFilters(%s) 失败: %w", filters, err)
+		}
+		var got []*nostr.Event
+		for evt := range ch {
+			got = append(got, evt)
+		}
+		if !reflect.DeepEqual(ids(got), ids(want)) {
+			return fmt.Errorf("QueryFilters(%s) 返回 %v，应为 %v", filters, ids(got), ids(want))
+		}
+		return nil
+	}
+
+	cases := []struct {
+		filters nostr.Filters
+		want    []*nostr.Event
+	}{
+		{nil, nil},
+		// 每个过滤器各自的 limit，合并后按时间排序
+		{nostr.Filters{{Kinds: []int{1}, Limit: 2}, {Kinds: []int{0}}}, []*nostr.Event{profile, notes[3], notes[2]}},
+		// 多个过滤器匹配的事件只返回一次
+		{nostr.Filters{{Kinds: []int{1}}, {Authors: []string{notes[0].PubKey}, Limit: 1}}, []*nostr.Event{notes[3], notes[2], notes[1], notes[0]}},
+		{nostr.Filters{{IDs: []string{notes[1].ID}}, {Kinds: []int{30023}}}, []*nostr.Event{notes[1]}},
+	}
+	for _, c := range cases {
+		if err := expect(c.filters, c.want...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkReplace 检查 eventstore.Store 的 ReplaceEvent，存储没有实现 eventstore.Store 时跳过
+func checkReplace(ctx context.Context, store orbitdb.Store, f *fixture) error {
+	es, ok := store.(eventstore.Store)
+	if !ok {
+		return fmt.Errorf("%w eventstore.Store", errSkip)
+	}
+	if err := es.Init(); err != nil {
+		return fmt.Errorf("Init 失败: %w", err)
+	}
+	replace := func(events ...*nostr.Event) error {
+		for _, evt := range events {
+			if err := es.ReplaceEvent(ctx, evt); err != nil {
+				return fmt.Errorf("ReplaceEvent(%s) 失败: %w", evt.ID, err)
+			}
+		}
+		return nil
+	}
+
+	// 可替换类型只保留最新的事件，更旧的事件不会替换它
+	old, err := f.event(f.alice, 0, 0, nil, "old profile")
+	if err != nil {
+		return err
+	}
+	profile, err := f.event(f.alice, 10, 0, nil, "profile")
+	if err != nil {
+		return err
+	}
+	other, err := f.event(f.bob, 0, 0, nil, "other profile")
+	if err != nil {
+		return err
+	}
+	if err := replace(old, profile, other); err != nil {
+		return err
+	}
+	if err := es.ReplaceEvent(ctx, old); !errors.Is(err, orbitdb.ErrNewerExists) {
+		return fmt.Errorf("用更旧的事件替换返回 %v，应为 ErrNewerExists", err)
+	}
+	if err := es.ReplaceEvent(ctx, profile); !errors.Is(err, orbitdb.ErrNewerExists) {
+		return fmt.Errorf("重复替换同一事件返回 %v，应为 ErrNewerExists", err)
+	}
+	if err := expectIDs(ctx, store, nostr.Filter{Kinds: []int{0}}, profile, other); err != nil {
+		return err
+	}
+
+	// 可寻址类型按 d 标签区分地址，没有 d 标签与空的 d 标签相同
+	a1, err := f.event(f.alice, 0, 30023, nostr.Tags{{"d", "a"}}, "a1")
+	if err != nil {
+		return err
+	}
+	a2, err := f.event(f.alice, 5, 30023, nostr.Tags{{"d", "a"}}, "a2")
+	if err != nil {
+		return err
+	}
+	b1, err := f.event(f.alice, 1, 30023, nostr.Tags{{"d", "b"}}, "b1")
+	if err != nil {
+		return err
+	}
+	empty, err := f.event(f.alice, 2, 30023, nil, "no d tag")
+	if err != nil {
+		return err
+	}
+	emptyD, err := f.event(f.alice, 3, 30023, nostr.Tags{{"d", ""}}, "empty d tag")
+	if err != nil {
+		return err
+	}
+	if err := replace(a1, b1, a2, empty, emptyD); err != nil {
+		return err
+	}
+	if err := expectIDs(ctx, store, nostr.Filter{Kinds: []int{30023}}, a2, emptyD, b1); err != nil {
+		return err
+	}
+
+	note, err := f.event(f.alice, 0, 1, nil, "note")
+	if err != nil {
+		return err
+	}
+	if err := es.ReplaceEvent(ctx, note); !errors.Is(err, orbitdb.ErrNotReplaceable) {
+		return fmt.Errorf("替换 kind 1 的事件返回 %v，应为 ErrNotReplaceable", err)
+	}
+	return nil
+}
+
+func checkClose(ctx context.Context, store orbitdb.Store, f *fixture) error {
+	evt, err := f.event(f.alice, 0, 1, nil, "before close")
+	if err != nil {
+		return err
+	}
+	if err := save(ctx, store, evt); err != nil {
+		return err
+	}
+	events, err := store.Subscribe(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Subscribe 失败: %w", err)
+	}
+
+	store.Close()
+	// 重复关闭没有影响
+	store.Close()
+
+	after, err := f.event(f.alice, 1, 1, nil, "after close")
+	if err != nil {
+		return err
+	}
+	if err := store.SaveEvent(ctx, after); !errors.Is(err, orbitdb.ErrClosed) {
+		return fmt.Errorf("关闭后 SaveEvent 返回 %v，应为 ErrClosed", err)
+	}
+	if err := store.DeleteEvent(ctx, evt); !errors.Is(err, orbitdb.ErrClosed) {
+		return fmt.Errorf("关闭后 DeleteEvent 返回 %v，应为 ErrClosed", err)
+	}
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return nil
+			}
+		case <-ctx.Done():
+			return errors.New("关闭后订阅的通道没有关闭")
+		}
+	}
+}
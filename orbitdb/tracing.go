@@ -0,0 +1,67 @@
+package orbitdb
+
+import (
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer 本包的 OpenTelemetry tracer。使用全局的 TracerProvider，
+// 调用方没有设置时 span 不会被记录。
+var tracer = otel.Tracer("github.com/maoaixiao1314/orbitdb/orbitdb")
+
+// filterAttributes 返回过滤器的 span 属性。ids 和 authors 只记录数量
+func filterAttributes(filter nostr.Filter) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("nostr.filter.shape", filterShape(filter)),
+	}
+	if len(filter.IDs) > 0 {
+		attrs = append(attrs, attribute.Int("nostr.filter.ids", len(filter.IDs)))
+	}
+	if len(filter.Authors) > 0 {
+		attrs = append(attrs, attribute.Int("nostr.filter.authors", len(filter.Authors)))
+	}
+	if len(filter.Kinds) > 0 {
+		attrs = append(attrs, attribute.IntSlice("nostr.filter.kinds", filter.Kinds))
+	}
+	if len(filter.Tags) > 0 {
+		tags := make([]string, 0, len(filter.Tags))
+		for tag := range filter.Tags {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		attrs = append(attrs, attribute.StringSlice("nostr.filter.tags", tags))
+	}
+	if filter.Since != nil {
+		attrs = append(attrs, attribute.Int64("nostr.filter.since", int64(*filter.Since)))
+	}
+	if filter.Until != nil {
+		attrs = append(attrs, attribute.Int64("nostr.filter.until", int64(*filter.Until)))
+	}
+	if filter.Limit > 0 {
+		attrs = append(attrs, attribute.Int("nostr.filter.limit", filter.Limit))
+	}
+	return attrs
+}
+
+// eventAttributes 返回事件的 span 属性
+func eventAttributes(evt *nostr.Event) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("nostr.event.id", evt.ID),
+		attribute.String("nostr.event.pubkey", evt.PubKey),
+		attribute.Int("nostr.event.kind", evt.Kind),
+	}
+}
+
+// endSpan 结束 span，err 不为 nil 时记录错误
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,107 @@
+package orbitdbpb
+
+import (
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// EventToProto converts a nostr event to its protobuf form.
+func EventToProto(evt *nostr.Event) *Event {
+	if evt == nil {
+		return nil
+	}
+	tags := make([]*Tag, 0, len(evt.Tags))
+	for _, tag := range evt.Tags {
+		tags = append(tags, &Tag{Values: append([]string(nil), tag...)})
+	}
+	return &Event{
+		Id:        evt.ID,
+		Pubkey:    evt.PubKey,
+		CreatedAt: int64(evt.CreatedAt),
+		Kind:      int32(evt.Kind),
+		Tags:      tags,
+		Content:   evt.Content,
+		Sig:       evt.Sig,
+	}
+}
+
+// EventFromProto converts a protobuf event to a nostr event.
+func EventFromProto(pb *Event) *nostr.Event {
+	if pb == nil {
+		return nil
+	}
+	tags := make(nostr.Tags, 0, len(pb.GetTags()))
+	for _, tag := range pb.GetTags() {
+		tags = append(tags, nostr.Tag(append([]string(nil), tag.GetValues()...)))
+	}
+	return &nostr.Event{
+		ID:        pb.GetId(),
+		PubKey:    pb.GetPubkey(),
+		CreatedAt: nostr.Timestamp(pb.GetCreatedAt()),
+		Kind:      int(pb.GetKind()),
+		Tags:      tags,
+		Content:   pb.GetContent(),
+		Sig:       pb.GetSig(),
+	}
+}
+
+// FilterToProto converts a nostr filter to its protobuf form. Tag filters
+// are sorted by name.
+func FilterToProto(filter nostr.Filter) *Filter {
+	pb := &Filter{
+		Ids:     filter.IDs,
+		Authors: filter.Authors,
+		Limit:   int32(filter.Limit),
+		Search:  filter.Search,
+	}
+	for _, kind := range filter.Kinds {
+		pb.Kinds = append(pb.Kinds, int32(kind))
+	}
+	names := make([]string, 0, len(filter.Tags))
+	for name := range filter.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pb.Tags = append(pb.Tags, &TagFilter{Name: name, Values: filter.Tags[name]})
+	}
+	if filter.Since != nil {
+		since := int64(*filter.Since)
+		pb.Since = &since
+	}
+	if filter.Until != nil {
+		until := int64(*filter.Until)
+		pb.Until = &until
+	}
+	return pb
+}
+
+// FilterFromProto converts a protobuf filter to a nostr filter. A nil
+// filter matches every event.
+func FilterFromProto(pb *Filter) nostr.Filter {
+	filter := nostr.Filter{
+		IDs:     pb.GetIds(),
+		Authors: pb.GetAuthors(),
+		Limit:   int(pb.GetLimit()),
+		Search:  pb.GetSearch(),
+	}
+	for _, kind := range pb.GetKinds() {
+		filter.Kinds = append(filter.Kinds, int(kind))
+	}
+	if len(pb.GetTags()) > 0 {
+		filter.Tags = make(nostr.TagMap, len(pb.GetTags()))
+		for _, tag := range pb.GetTags() {
+			filter.Tags[tag.GetName()] = append(filter.Tags[tag.GetName()], tag.GetValues()...)
+		}
+	}
+	if pb != nil && pb.Since != nil {
+		since := nostr.Timestamp(pb.GetSince())
+		filter.Since = &since
+	}
+	if pb != nil && pb.Until != nil {
+		until := nostr.Timestamp(pb.GetUntil())
+		filter.Until = &until
+	}
+	return filter
+}
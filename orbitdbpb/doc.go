@@ -0,0 +1,5 @@
+// Package orbitdbpb holds the protobuf messages and the gRPC EventStore
+// service generated from orbitdb.proto.
+package orbitdbpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative orbitdb.proto
@@ -5,8 +5,8 @@ set -e
 # 参数
 NODES=3
 BINARY="./orbitdb"
-DATA_DIR="~/data"
-LOG_DIR="~/logs"
+DATA_DIR="./data/multi"
+LOG_DIR="./logs/multi"
 START_PORT=4001
 API_PORT=5001  # 可根据你的实现调整
 WAIT_DB=5
@@ -15,14 +15,16 @@ WAIT_DB=5
 rm -rf "$DATA_DIR" "$LOG_DIR"
 mkdir -p "$LOG_DIR"
 
+# 各节点通过 mDNS 在本机/局域网内互相发现，无需 DHT 或手动 swarm connect
 # 1. 启动第一个节点（新建数据库）
 echo "=== 启动 node1，生成新数据库... ==="
-"$BINARY" -data "${DATA_DIR}/node1" -listen "/ip4/0.0.0.0/tcp/$START_PORT" > "${LOG_DIR}/node1.log" 2>&1 &
+"$BINARY" -data "${DATA_DIR}/node1" -listen "/ip4/0.0.0.0/tcp/$START_PORT" -mdns -log-format json > "${LOG_DIR}/node1.log" 2>&1 &
 NODE1_PID=$!
 sleep $WAIT_DB
 
 # 2. 提取数据库地址
-DB_ADDR=$(grep -m 1 "Database created with address:" "${LOG_DIR}/node1.log" | awk '{print $NF}')
+# 日志为每行一个 JSON 对象，取 msg 为 "database created" 的记录中的 address 字段
+DB_ADDR=$(grep -m 1 '"msg":"database created"' "${LOG_DIR}/node1.log" | sed -n 's/.*"address":"\([^"]*\)".*/\1/p')
 if [ -z "$DB_ADDR" ]; then
     echo "未成功获取数据库地址，检查 node1 日志：${LOG_DIR}/node1.log"
     kill $NODE1_PID
@@ -34,7 +36,7 @@ echo "发现数据库地址：$DB_ADDR"
 for n in 2 3; do
     port=$((START_PORT + n - 1))
     echo "=== 启动 node$n，连接数据库 $DB_ADDR... ==="
-    "$BINARY" -data "${DATA_DIR}/node$n" -listen "/ip4/0.0.0.0/tcp/$port" -db "$DB_ADDR" > "${LOG_DIR}/node${n}.log" 2>&1 &
+    "$BINARY" -data "${DATA_DIR}/node$n" -listen "/ip4/0.0.0.0/tcp/$port" -db "$DB_ADDR" -mdns -log-format json > "${LOG_DIR}/node${n}.log" 2>&1 &
     eval NODE${n}_PID=\$!
     sleep 3
 done
@@ -18,22 +18,6 @@ if [ -n "$1" ]; then
   SETTINGS_DIR="$DATA_DIR/settings"
 fi
 
-# 检查 IPFS 锁文件并清理
-LOCK_FILE="$IPFS_DIR/repo.lock"
-API_LOCK_FILE="$IPFS_DIR/api"
-
-if [ -f "$LOCK_FILE" ]; then
-  echo "==> 检测到锁文件，清理中..."
-  rm -f "$LOCK_FILE"
-  echo "==> 已删除 $LOCK_FILE"
-fi
-
-if [ -f "$API_LOCK_FILE" ]; then
-  echo "==> 检测到 API 锁文件，清理中..."
-  rm -f "$API_LOCK_FILE"
-  echo "==> 已删除 $API_LOCK_FILE"
-fi
-
 # 1. 初始化 IPFS
 if [ ! -d "$IPFS_DIR" ] || [ ! -f "$IPFS_DIR/config" ]; then
   echo "==> 初始化 IPFS 仓库 ($IPFS_DIR)..."
@@ -65,9 +49,19 @@ do
 done
 
 # 3. 启动 OrbitDB 节点
+# 收到 SIGINT/SIGTERM 时转发给节点，由节点按顺序关闭并释放仓库锁
 echo "==> 启动 OrbitDB 节点..."
-./orbitdb -data "$DATA_DIR" -listen "$LISTEN_ADDR" -ipfs "127.0.0.1:$IPFS_API_PORT"
+./orbitdb -data "$DATA_DIR" -listen "$LISTEN_ADDR" -ipfs "127.0.0.1:$IPFS_API_PORT" &
+ORBITDB_PID=$!
+trap 'kill -TERM $ORBITDB_PID 2>/dev/null' INT TERM
 # ./orbitdb -data ./data/node1 -listen /ip4/0.0.0.0/tcp/4001 -ipfs "127.0.0.1:5001"
+# 第一次 wait 会被信号打断，第二次等待节点真正退出
+set +e
+wait $ORBITDB_PID
+wait $ORBITDB_PID
+set -e
+
 # 4. 退出时关闭 IPFS
 echo "==> 正在退出，关闭 IPFS daemon..."
-kill $IPFS_PID
+kill -TERM $IPFS_PID
+wait $IPFS_PID 2>/dev/null || true